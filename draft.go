@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+)
+
+// Lobbies with the draft option start on an empty board. The players ban and
+// pick elements in the order of DRAFT_ORDER, the seat moving second in the
+// game drafts first, and the board is generated from the picks once the last
+// element is taken, or the placement starts with them.
+
+type DraftStep struct {
+	// <<<
+	Second bool // made by the seat moving second
+	Ban    bool
+	// >>>
+}
+
+var DRAFT_ORDER = []DraftStep{
+	// <<<
+	{Second: true, Ban: true},
+	{Second: false, Ban: true},
+	{Second: true, Ban: false},
+	{Second: false, Ban: false},
+	{Second: false, Ban: false},
+	{Second: true, Ban: false},
+	// >>>
+}
+
+type Draft struct {
+	// <<<
+	Picks [2][]Element `json:"picks"` // by seat
+	Bans  []Element    `json:"bans"`
+	Step  int          `json:"step"` // index into DRAFT_ORDER
+	// >>>
+}
+
+// what clients need to show the draft
+type DraftState struct {
+	// <<<
+	Draft
+	Seat int  `json:"seat"` // to ban or pick next
+	Ban  bool `json:"ban"`
+	// >>>
+}
+
+func draft_state(gw GameWrapper) *DraftState {
+	// <<<
+	if !drafting(gw) {
+		return nil
+	}
+	state := DraftState{Draft: gw.Draft, Seat: draft_seat(gw), Ban: DRAFT_ORDER[gw.Draft.Step].Ban}
+	// lists rather than nulls for clients
+	state.Bans = append([]Element{}, gw.Draft.Bans...)
+	for p := range state.Picks {
+		state.Picks[p] = append([]Element{}, gw.Draft.Picks[p]...)
+	}
+	return &state
+	// >>>
+}
+
+func drafting(gw GameWrapper) bool {
+	return gw.Options.Draft && gw.Draft.Step < len(DRAFT_ORDER)
+}
+
+// the seat to ban or pick next
+func draft_seat(gw GameWrapper) int {
+	// <<<
+	if DRAFT_ORDER[gw.Draft.Step].Second {
+		return 1 - gw.First
+	}
+	return gw.First
+	// >>>
+}
+
+func draft_element(gw *GameWrapper, player_index int, element Element) error {
+	// <<<
+	if !drafting(*gw) {
+		return fmt.Errorf("There is no draft going on")
+	}
+	if !seated(*gw) {
+		return fmt.Errorf("The draft starts once both players are seated")
+	}
+	if player_index != draft_seat(*gw) {
+		return fmt.Errorf("Not your turn to draft")
+	}
+	if !slices.Contains(ELEMENTS, element) {
+		return fmt.Errorf("Invalid Element")
+	}
+	draft := &gw.Draft
+	if slices.Contains(draft.Bans, element) || slices.Contains(draft.Picks[0], element) || slices.Contains(draft.Picks[1], element) {
+		return fmt.Errorf("The element is already taken")
+	}
+
+	if DRAFT_ORDER[draft.Step].Ban {
+		draft.Bans = append(draft.Bans, element)
+	} else {
+		draft.Picks[player_index] = append(draft.Picks[player_index], element)
+	}
+	draft.Step += 1
+
+	if !drafting(*gw) && gw.Options.Placement {
+		start_placement(gw, draft.Picks)
+	} else if !drafting(*gw) {
+		game := generate_game(gw.Rules, rand.New(rand.NewSource(gw.Seed)), draft.Picks, gw.Options.Layout, gw.Options.Fair)
+		game.ActivePlayer = gw.Game.ActivePlayer
+		game.Players, game.Loadouts = gw.Game.Players, gw.Game.Loadouts
+		gw.Game = game
+		gw.Start = format_position(gw.Game, gw.SkipAdvance)
+	}
+	return nil
+	// >>>
+}
+
+func draft(lobby_id, player_id string, element Element) (gw GameWrapper, err error) {
+	// <<<
+	games.Lock()
+	defer games.Unlock()
+
+	gw, found := games.m[lobby_id]
+	if !found {
+		return gw, fmt.Errorf("Invalid Lobby ID")
+	}
+
+	touch(&gw, player_id)
+	seat := seat_of(gw, player_id)
+	if gw.Options.Hotseat && gw.Owner == player_id && drafting(gw) {
+		seat = draft_seat(gw)
+	}
+	err = draft_element(&gw, seat, element)
+	games.m[lobby_id] = gw
+	return gw, err
+	// >>>
+}
+
+// =============================================================================
+
+func handle_draft(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		LobbyID  string  `json:"lobby_id"`
+		PlayerID string  `json:"player_id"`
+		Element  Element `json:"element"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	gw, err := draft(data.LobbyID, data.PlayerID, data.Element)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := struct {
+		Ok      bool        `json:"ok"`
+		Draft   *DraftState `json:"draft"` // null once the draft is over
+		GameSOA GameSOA     `json:"game_soa"`
+	}{
+		Ok:      true,
+		Draft:   draft_state(gw),
+		GameSOA: aos2soa(gw.Game),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	// >>>
+}
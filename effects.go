@@ -0,0 +1,71 @@
+package main
+
+// Status effects are stored per elemental in Cell.Status as the number of
+// ends of turns of its owner they still last, 0 when the effect is not
+// active. They count down at the end of every turn of the owner, the turn
+// they were applied in included. So an effect for n turns applied by the
+// enemy lasts through the next n turns of the owner, and one applied on an
+// own turn loses a turn right away and lasts through the next n-1 turns of
+// the owner and the n-1 enemy turns in between. Effects go with the
+// elemental when it moves and are gone when it dies.
+
+type Effect int
+
+const ( // <<<
+	BURN   Effect = iota // loses BURN_DAMAGE health at the end of every own turn
+	STUN                 // may not attack
+	SHIELD               // the next hit does no damage and ends the shield
+	HASTE                // HASTE_REACH more reach
+	EFFECT_COUNT
+
+	BURN_DAMAGE = 1
+	HASTE_REACH = 2
+) // >>>
+
+var ( // <<<
+	EFFECT_NAMES   = [EFFECT_COUNT]string{"burn", "stun", "shield", "haste"}
+	EFFECT_LETTERS = [EFFECT_COUNT]byte{'b', 't', 's', 'h'} // of the position notation
+) // >>>
+
+func has_effect(cell Cell, effect Effect) bool {
+	return cell.Type == ELEMENTAL && cell.Status[effect] > 0
+}
+
+// an effect applied twice lasts for the longer of both durations
+func apply_effect(board Board, p Pos, effect Effect, turns int) {
+	// <<<
+	if board[p.Row][p.Col].Type != ELEMENTAL {
+		return
+	}
+	board[p.Row][p.Col].Status[effect] = max(board[p.Row][p.Col].Status[effect], turns)
+	// >>>
+}
+
+func reach(rules Ruleset, cell Cell) int {
+	// <<<
+	r := rules.Reach[cell.Level-1] + trait_strength(rules, cell, TRAIT_REACH)
+	if has_effect(cell, HASTE) {
+		r += HASTE_REACH
+	}
+	return r
+	// >>>
+}
+
+// the end of a turn of seat, burns first so the last turn of a burn hurts too
+func tick_effects(rules Ruleset, game *Game, seat int) {
+	// <<<
+	for row := range game.Board {
+		for col := range game.Board[row] {
+			if owner(game.Board, row) != seat || game.Board[row][col].Type != ELEMENTAL {
+				continue
+			}
+			if has_effect(game.Board[row][col], BURN) {
+				apply_damage(rules, game, row, col, BURN_DAMAGE)
+			}
+			for e := range game.Board[row][col].Status {
+				game.Board[row][col].Status[e] = max(game.Board[row][col].Status[e]-1, 0)
+			}
+		}
+	}
+	// >>>
+}
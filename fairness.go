@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math/rand"
+)
+
+// Board generation layouts and a fairness scorer. A mirrored layout reflects
+// the top half onto the bottom half at the middle line, a rotated one turns it
+// by half a circle around the center of the board. Either way the elementals
+// of seat 0 use the element at the same index in its side as the ones of
+// seat 1 they are copied from.
+//
+// The scorer counts near merges, merge configurations missing only one
+// elemental, and attack lanes, elementals with an enemy in reach, for both
+// seats. Lobbies with the fair option generate boards until the counts of the
+// two seats are within FAIRNESS_TOLERANCE percent of each other.
+
+type Layout string
+
+const (
+	LAYOUT_RANDOM Layout = "random"
+	LAYOUT_MIRROR Layout = "mirror"
+	LAYOUT_ROTATE Layout = "rotate"
+)
+
+const (
+	FAIRNESS_TOLERANCE = 20  // percent of the sum of both seats
+	FAIRNESS_SLACK     = 2   // difference which is always accepted
+	FAIRNESS_ATTEMPTS  = 200 // boards generated before the fairest one is taken
+)
+
+type Fairness struct {
+	// <<<
+	NearMerges  [2]int `json:"near_merges"`  // by seat
+	AttackLanes [2]int `json:"attack_lanes"` // by seat
+	// >>>
+}
+
+func mirror_pos(size int, p Pos, layout Layout) Pos {
+	// <<<
+	if layout == LAYOUT_ROTATE {
+		return Pos{size - 1 - p.Row, size - 1 - p.Col}
+	}
+	return Pos{size - 1 - p.Row, p.Col}
+	// >>>
+}
+
+func make_symmetric_game(rules Ruleset, rng *rand.Rand, sides [2][]Element, layout Layout) Game {
+	// <<<
+	game := make_empty_game(rules)
+
+	num_elementals := random_elemental_count(rules, rng)
+	top := []Pos{}
+	for row := 0; row < rules.Size/2; row++ {
+		for col := 0; col < rules.Size; col++ {
+			top = append(top, Pos{row, col})
+		}
+	}
+	rng.Shuffle(len(top), func(i, j int) {
+		top[i], top[j] = top[j], top[i]
+	})
+
+	for _, p := range top[:num_elementals] {
+		k := rng.Intn(len(sides[1]))
+		place_elemental(rules, game.Board, p, sides[1][k])
+		place_elemental(rules, game.Board, mirror_pos(rules.Size, p, layout), sides[0][k%len(sides[0])])
+	}
+
+	return game
+	// >>>
+}
+
+func score_fairness(rules Ruleset, board Board) Fairness {
+	// <<<
+	var f Fairness
+	size := len(board)
+
+	for seat := 0; seat < 2; seat++ {
+		o := (1 - seat) * size / 2
+		for row := 0; row < size/2; row++ {
+			for col := 0; col < size; col++ {
+				for _, shape := range rules.MergeShapes {
+					cells, ok := shape_cells(size, shape, row, col)
+					if !ok {
+						continue
+					}
+					elementals, empty := []Cell{}, 0
+					for _, p := range cells {
+						cell := board[p.Row+o][p.Col]
+						switch cell.Type {
+						case ELEMENTAL:
+							elementals = append(elementals, cell)
+						case EMPTY:
+							empty += 1
+						}
+					}
+					if len(elementals) != len(cells)-1 || empty != 1 {
+						continue
+					}
+					near := elementals[0].Level < max_level(rules)
+					for _, cell := range elementals {
+						near = near && cell.Element == elementals[0].Element && cell.Level == elementals[0].Level
+					}
+					if near {
+						f.NearMerges[seat] += 1
+					}
+				}
+			}
+		}
+	}
+
+	for row := 0; row < size; row++ {
+		for col := 0; col < size; col++ {
+			if able_to_attack(rules, board, row, col) {
+				f.AttackLanes[owner(board, row)] += 1
+			}
+		}
+	}
+
+	return f
+	// >>>
+}
+
+func imbalance(a, b int) int {
+	return max(0, abs(a-b)-max(FAIRNESS_SLACK, (a+b)*FAIRNESS_TOLERANCE/100))
+}
+
+func fair(f Fairness) bool {
+	return imbalance(f.NearMerges[0], f.NearMerges[1]) == 0 && imbalance(f.AttackLanes[0], f.AttackLanes[1]) == 0
+}
+
+// a board in the layout, the fairest of FAIRNESS_ATTEMPTS if none is fair
+func generate_game(rules Ruleset, rng *rand.Rand, sides [2][]Element, layout Layout, fairness bool) Game {
+	// <<<
+	best, best_imbalance := Game{}, -1
+	for i := 0; i < FAIRNESS_ATTEMPTS; i++ {
+		var game Game
+		switch layout {
+		case LAYOUT_MIRROR, LAYOUT_ROTATE:
+			game = make_symmetric_game(rules, rng, sides, layout)
+		default:
+			game = make_game_with_elements(rules, rng, sides)
+		}
+		if !fairness {
+			return game
+		}
+		f := score_fairness(rules, game.Board)
+		if fair(f) {
+			return game
+		}
+		if d := imbalance(f.NearMerges[0], f.NearMerges[1]) + imbalance(f.AttackLanes[0], f.AttackLanes[1]); best_imbalance == -1 || d < best_imbalance {
+			best, best_imbalance = game, d
+		}
+	}
+	return best
+	// >>>
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Lobbies with the loadout option start with a loadout phase next to the
+// draft and the placement. Once both players are seated, every player picks
+// LOADOUT_SIZE spells out of SPELLS, the picks stay hidden until both are
+// ready and then replace the loadouts and the charges of the game.
+
+type Loadout struct {
+	// <<<
+	Spells [2][LOADOUT_SIZE]SpellID `json:"-"` // by seat, hidden until done
+	Ready  [2]bool                  `json:"ready"`
+	Done   bool                     `json:"-"`
+	// >>>
+}
+
+func choosing(gw GameWrapper) bool {
+	return gw.Options.Loadout && !gw.Loadout.Done
+}
+
+// what clients need to show the loadout phase
+func loadout_state(gw GameWrapper) *Loadout {
+	// <<<
+	if !choosing(gw) {
+		return nil
+	}
+	state := gw.Loadout
+	return &state
+	// >>>
+}
+
+func choose_loadout(gw *GameWrapper, player_index int, spells [LOADOUT_SIZE]SpellID) error {
+	// <<<
+	if !choosing(*gw) {
+		return fmt.Errorf("There is no loadout to choose")
+	}
+	if !seated(*gw) {
+		return fmt.Errorf("The loadouts are chosen once both players are seated")
+	}
+	if player_index < 0 || gw.Loadout.Ready[player_index] {
+		return fmt.Errorf("Your loadout is already chosen")
+	}
+	if err := validate_loadout(spells); err != nil {
+		return err
+	}
+
+	gw.Loadout.Spells[player_index] = spells
+	gw.Loadout.Ready[player_index] = true
+	if gw.Loadout.Ready[0] && gw.Loadout.Ready[1] {
+		finish_loadout(gw)
+	}
+	return nil
+	// >>>
+}
+
+func finish_loadout(gw *GameWrapper) {
+	// <<<
+	for seat := 0; seat < 2; seat++ {
+		gw.Game.Loadouts[seat] = gw.Loadout.Spells[seat]
+		gw.Game.Players[seat] = loadout_charges(gw.Rules, gw.Loadout.Spells[seat])
+	}
+	gw.Loadout.Done = true
+	gw.Start = format_position(gw.Game, gw.SkipAdvance)
+	// >>>
+}
+
+func loadout(lobby_id, player_id string, spells [LOADOUT_SIZE]SpellID) (gw GameWrapper, err error) {
+	// <<<
+	games.Lock()
+	defer games.Unlock()
+
+	gw, found := games.m[lobby_id]
+	if !found {
+		return gw, fmt.Errorf("Invalid Lobby ID")
+	}
+
+	touch(&gw, player_id)
+	seat := seat_of(gw, player_id)
+	if gw.Options.Hotseat && gw.Owner == player_id && !gw.Loadout.Ready[0] {
+		seat = 0
+	} else if gw.Options.Hotseat && gw.Owner == player_id {
+		seat = 1
+	}
+	err = choose_loadout(&gw, seat, spells)
+	games.m[lobby_id] = gw
+	return gw, err
+	// >>>
+}
+
+// =============================================================================
+
+func handle_loadout(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		LobbyID  string                `json:"lobby_id"`
+		PlayerID string                `json:"player_id"`
+		Spells   [LOADOUT_SIZE]SpellID `json:"spells"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	gw, err := loadout(data.LobbyID, data.PlayerID, data.Spells)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := struct {
+		Ok      bool     `json:"ok"`
+		Loadout *Loadout `json:"loadout"` // null once the loadouts are chosen
+		GameSOA GameSOA  `json:"game_soa"`
+	}{
+		Ok:      true,
+		Loadout: loadout_state(gw),
+		GameSOA: aos2soa(gw.Game),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	// >>>
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"cmp"
+	crypto_rand "crypto/rand"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
@@ -25,6 +27,26 @@ func make_id(length int) string {
 	// >>>
 }
 
+// like make_id but from crypto/rand, for secrets like the seat tokens
+func make_token(length int) string {
+	// <<<
+	const characters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	result := make([]byte, 0, length)
+	random := make([]byte, length)
+	for len(result) < length {
+		if _, err := crypto_rand.Read(random); err != nil {
+			panic(err)
+		}
+		for _, r := range random {
+			if int(r) < 256/len(characters)*len(characters) && len(result) < length { // no modulo bias
+				result = append(result, characters[int(r)%len(characters)])
+			}
+		}
+	}
+	return string(result)
+	// >>>
+}
+
 func clamp[T cmp.Ordered](v, a, b T) T {
 	// <<<
 	return max(a, min(b, v))
@@ -59,13 +81,11 @@ func pretty_print(i interface{}) string {
 // =============================================================================
 
 type Element string
-type Spell string
+type SpellID string
 type CellType string
 type ActionType string
 
 const ( // <<<
-	SIZE = 12
-
 	SKIP   ActionType = "skip"
 	SPELL  ActionType = "spell"
 	MOVE   ActionType = "move"
@@ -82,89 +102,215 @@ const ( // <<<
 	NATURE Element = "nature"
 	ENERGY Element = "energy"
 
-	FS Spell = "fs"
-	HV Spell = "hv"
-	AF Spell = "af"
-	DT Spell = "dt"
-	MS Spell = "ms"
+	FS SpellID = "fs"
+	HV SpellID = "hv"
+	AF SpellID = "af"
+	DT SpellID = "dt"
+	MS SpellID = "ms"
+	SH SpellID = "sh"
+	SW SpellID = "sw"
+	FZ SpellID = "fz"
+	SU SpellID = "su"
+	IG SpellID = "ig"
+	HA SpellID = "ha"
+
+	LOADOUT_SIZE = 5 // spells per player
 ) // >>>
 
 var ( // <<<
 	ACTION_TYPES = []ActionType{SKIP, SPELL, MOVE, ATTACK}
 	CELL_TYPES   = []CellType{EMPTY, ELEMENTAL, BLOCK}
-	SPELLS       = []Spell{FS, HV, AF, DT, MS}
+	SPELLS       = []SpellID{FS, HV, AF, DT, MS, SH, SW, FZ, SU, IG, HA} // the pool to choose loadouts from
 	ELEMENTS     = []Element{AIR, ROCK, FIRE, WATER, NATURE, ENERGY}
-	LEVELS       = []int{1, 2, 3} // 111 -> _2_ points+1 | 222 -> _3_ points+2
-	HEALTH       = []int{1, 2, 6}
-	DAMAGE       = []int{1, 2, 4}
-	REACH        = []int{3, 5, 7}
-	CHARGES      = []int{4, 5, 7, 9, 10}
-	SPELL_DAMAGE = []int{2, -1, 1, -1, 4}
+
+	DEFAULT_LOADOUT = [LOADOUT_SIZE]SpellID{FS, HV, AF, DT, MS}
 ) // >>>
 
-type Board [SIZE][SIZE]struct {
+type Cell struct {
 	// <<<
-	Type    CellType `json:"type"`
-	Element Element  `json:"element"`
-	Health  int      `json:"health"`
-	Level   int      `json:"level"`
+	Type    CellType          `json:"type"`
+	Element Element           `json:"element"`
+	Health  int               `json:"health"`
+	Level   int               `json:"level"`
+	Status  [EFFECT_COUNT]int `json:"status"` // turns left by effect, see effects.go
 	// >>>
 }
 
+// square with an even size, rows first
+type Board [][]Cell
+
 type Game struct {
 	// <<<
-	Board        Board     `json:"board"`
-	Players      [2][5]int `json:"players"`
-	ActivePlayer int       `json:"active_player"`
-	Turn         int       `json:"turn"`
+	Board        Board                    `json:"board"`
+	Players      [2][LOADOUT_SIZE]int     `json:"players"`  // charges by seat and loadout slot
+	Loadouts     [2][LOADOUT_SIZE]SpellID `json:"loadouts"` // by seat
+	ActivePlayer int                      `json:"active_player"`
+	Turn         int                      `json:"turn"`
 	// >>>
 }
 
 type BoardSOA struct {
 	// <<<
-	Type    [SIZE][SIZE]CellType `json:"type"`
-	Element [SIZE][SIZE]Element  `json:"element"`
-	Health  [SIZE][SIZE]int      `json:"health"`
-	Level   [SIZE][SIZE]int      `json:"level"`
+	Type    [][]CellType          `json:"type"`
+	Element [][]Element           `json:"element"`
+	Health  [][]int               `json:"health"`
+	Level   [][]int               `json:"level"`
+	Status  [][][EFFECT_COUNT]int `json:"status"`
 	// >>>
 }
 
 type GameSOA struct {
 	// <<<
-	BoardSOA     BoardSOA  `json:"board_soa"`
-	Players      [2][5]int `json:"players"`
-	ActivePlayer int       `json:"active_player"`
-	Turn         int       `json:"turn"`
+	BoardSOA     BoardSOA                 `json:"board_soa"`
+	Players      [2][LOADOUT_SIZE]int     `json:"players"`
+	Loadouts     [2][LOADOUT_SIZE]SpellID `json:"loadouts"`
+	ActivePlayer int                      `json:"active_player"`
+	Turn         int                      `json:"turn"`
 	// >>>
 }
 
 type GameWrapper struct {
 	// <<<
 	Game              Game
-	Players           []string
+	Rules             Ruleset
+	Players           []string // one player id per seat, "" while the seat is open
 	PlayerCanUseSpell []bool
+	Owner             string       // the player id which created the lobby
+	Tokens            [2]string    // secret per seat to reclaim it with another player id
+	LastSeen          [2]time.Time // per seat
+	Forfeit           [2]bool      // per seat
 	CreatedAt         time.Time
 	LastAccessedAt    time.Time
 	SkipAdvance       int
+	Seed              int64    // 0 when the board was not generated
+	Start             string   // position notation of the first position
+	History           []Action // accepted actions, in order
+	Sandbox           bool     // the owner may edit the board until the first action
+	Options           LobbyOptions
+	Creator           int // seat of the owner
+	First             int // seat which moved first
+	Series            Series
+	Finished          bool   // the result is counted in Series
+	Next              string // lobby id of the rematch or of the next game of the series
+	Draft             Draft
+	Placement         Placement
+	Loadout           Loadout
+	Cascade           Cascade // of the last turn
+	// >>>
+}
+
+type First string
+
+const (
+	FIRST_CREATOR  First = "creator"
+	FIRST_OPPONENT First = "opponent"
+	FIRST_RANDOM   First = "random"
+)
+
+type Side string
+
+const (
+	BOTTOM Side = "bottom" // seat 0
+	TOP    Side = "top"    // seat 1
+)
+
+type LobbyOptions struct {
+	// <<<
+	Hotseat   bool   `json:"hotseat"`   // one player id plays both seats
+	First     First  `json:"first"`     // "" keeps the active player of the position
+	Side      Side   `json:"side"`      // of the creator, "" is the bottom
+	BestOf    int    `json:"best_of"`   // 0 or 1 for a single game, else 3, 5 or 7
+	Draft     bool   `json:"draft"`     // the players draft the elements of the board
+	Placement bool   `json:"placement"` // the players place their elementals themselves
+	Layout    Layout `json:"layout"`    // of generated boards, "" is random
+	Fair      bool   `json:"fair"`      // generated boards have to pass score_fairness
+	Rules     string `json:"rules"`     // name of one of RULESETS, "" is classic
+	Loadout   bool   `json:"loadout"`   // the players choose their spells from the pool
+	// >>>
+}
+
+// games of a series are separate lobbies linked by GameWrapper.Next, every
+// one with the sides of the one before swapped
+type Series struct {
+	// <<<
+	BestOf int    `json:"best_of"`
+	Number int    `json:"number"` // of the current game, from 1
+	Score  [2]int `json:"score"`  // wins by seat of the current game
+	// >>>
+}
+
+type Pos struct {
+	// <<<
+	Row int `json:"row"`
+	Col int `json:"col"`
+	// >>>
+}
+
+type Action struct {
+	// <<<
+	Type  ActionType `json:"type"`  // skip == other fields are ignored
+	Spell SpellID    `json:"spell"` // empty means none was used
+	From  Pos        `json:"from"`
+	To    Pos        `json:"to"`
 	// >>>
 }
 
 // =============================================================================
 
-func valid(row, col int) bool {
-	return row >= 0 && row < SIZE && col >= 0 && col < SIZE
+func valid(board Board, row, col int) bool {
+	return row >= 0 && row < len(board) && col >= 0 && col < len(board)
+}
+
+func make_board(size int) Board {
+	// <<<
+	board := make(Board, size)
+	for i := range board {
+		board[i] = make([]Cell, size)
+	}
+	return board
+	// >>>
+}
+
+// boards share their cells when assigned, and lobbies are read outside of
+// the lock, so stored boards are copied before they change
+func copy_board(board Board) Board {
+	// <<<
+	next := make_board(len(board))
+	for i := range board {
+		copy(next[i], board[i])
+	}
+	return next
+	// >>>
+}
+
+func make_grid[T any](size int) [][]T {
+	// <<<
+	grid := make([][]T, size)
+	for i := range grid {
+		grid[i] = make([]T, size)
+	}
+	return grid
+	// >>>
 }
 
 func aos2soaB(aos Board) BoardSOA {
 	// <<<
-	soa := BoardSOA{}
+	size := len(aos)
+	soa := BoardSOA{
+		Type:    make_grid[CellType](size),
+		Element: make_grid[Element](size),
+		Health:  make_grid[int](size),
+		Level:   make_grid[int](size),
+		Status:  make_grid[[EFFECT_COUNT]int](size),
+	}
 
-	for i := 0; i < SIZE; i++ {
-		for j := 0; j < SIZE; j++ {
+	for i := 0; i < size; i++ {
+		for j := 0; j < size; j++ {
 			soa.Type[i][j] = aos[i][j].Type
 			soa.Element[i][j] = aos[i][j].Element
 			soa.Health[i][j] = aos[i][j].Health
 			soa.Level[i][j] = aos[i][j].Level
+			soa.Status[i][j] = aos[i][j].Status
 		}
 	}
 
@@ -174,38 +320,35 @@ func aos2soaB(aos Board) BoardSOA {
 
 func aos2soa(aos Game) GameSOA {
 	// <<<
-	soa := GameSOA{
+	return GameSOA{
+		BoardSOA:     aos2soaB(aos.Board),
 		Players:      aos.Players,
+		Loadouts:     aos.Loadouts,
 		ActivePlayer: aos.ActivePlayer,
 		Turn:         aos.Turn,
 	}
-
-	for i := 0; i < SIZE; i++ {
-		for j := 0; j < SIZE; j++ {
-			soa.BoardSOA.Type[i][j] = aos.Board[i][j].Type
-			soa.BoardSOA.Element[i][j] = aos.Board[i][j].Element
-			soa.BoardSOA.Health[i][j] = aos.Board[i][j].Health
-			soa.BoardSOA.Level[i][j] = aos.Board[i][j].Level
-		}
-	}
-
-	return soa
 	// >>>
 }
 func soa2aos(soa GameSOA) Game {
 	// <<<
+	size := len(soa.BoardSOA.Type)
 	aos := Game{
+		Board:        make_board(size),
 		Players:      soa.Players,
+		Loadouts:     soa.Loadouts,
 		ActivePlayer: soa.ActivePlayer,
 		Turn:         soa.Turn,
 	}
 
-	for i := 0; i < SIZE; i++ {
-		for j := 0; j < SIZE; j++ {
+	for i := 0; i < size; i++ {
+		for j := 0; j < size; j++ {
 			aos.Board[i][j].Type = soa.BoardSOA.Type[i][j]
 			aos.Board[i][j].Element = soa.BoardSOA.Element[i][j]
 			aos.Board[i][j].Health = soa.BoardSOA.Health[i][j]
 			aos.Board[i][j].Level = soa.BoardSOA.Level[i][j]
+			if soa.BoardSOA.Status != nil {
+				aos.Board[i][j].Status = soa.BoardSOA.Status[i][j]
+			}
 		}
 	}
 
@@ -243,40 +386,63 @@ func soa2aos(soa GameSOA) Game {
 // 	// >>>
 // }
 
-func make_initial_game() Game {
+func make_empty_game(rules Ruleset) Game {
 	// <<<
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 	var game Game
 
 	game.ActivePlayer = 0
 	game.Turn = 1
-
 	for i := 0; i < 2; i++ {
-		for j := 0; j < 5; j++ {
-			game.Players[i][j] = CHARGES[j]
-		}
+		game.Loadouts[i] = DEFAULT_LOADOUT
+		game.Players[i] = loadout_charges(rules, DEFAULT_LOADOUT)
 	}
-
-	for i := 0; i < SIZE; i++ {
-		for j := 0; j < SIZE; j++ {
+	game.Board = make_board(rules.Size)
+	for i := 0; i < rules.Size; i++ {
+		for j := 0; j < rules.Size; j++ {
 			game.Board[i][j].Type = EMPTY
 		}
 	}
 
+	return game
+	// >>>
+}
+
+func make_initial_game(rules Ruleset, rng *rand.Rand) Game {
+	return make_game_with_elements(rules, rng, random_sides(rng))
+}
+
+// one or two distinct elements per seat
+func random_sides(rng *rand.Rand) [2][]Element {
+	// <<<
 	elements := make([]Element, len(ELEMENTS))
 	copy(elements, ELEMENTS)
 	rng.Shuffle(len(elements), func(i, j int) {
 		elements[i], elements[j] = elements[j], elements[i]
 	})
 	num_elements_per_player := rng.Intn(2) + 1
-	num_elementals := 25 + rng.Intn(20+1) - 10 // 15..=35
-
-	all_pos_low := [SIZE * SIZE / 2][2]int{}
-	all_pos_high := [SIZE * SIZE / 2][2]int{}
-	for i := 0; i < SIZE/2; i++ {
-		for j := 0; j < SIZE; j++ {
-			all_pos_low[i*SIZE+j] = [2]int{i, j}
-			all_pos_high[i*SIZE+j] = [2]int{i + SIZE/2, j}
+
+	return [2][]Element{
+		elements[2 : 2+num_elements_per_player], // the bottom half
+		elements[:num_elements_per_player],
+	}
+	// >>>
+}
+
+// random positions in both halves, the elementals of seat p are of the
+// elements in sides[p]
+func make_game_with_elements(rules Ruleset, rng *rand.Rand, sides [2][]Element) Game {
+	// <<<
+	game := make_empty_game(rules)
+
+	num_elementals := random_elemental_count(rules, rng)
+
+	size := rules.Size
+	all_pos_low := make([][2]int, size*size/2)
+	all_pos_high := make([][2]int, size*size/2)
+	for i := 0; i < size/2; i++ {
+		for j := 0; j < size; j++ {
+			all_pos_low[i*size+j] = [2]int{i, j}
+			all_pos_high[i*size+j] = [2]int{i + size/2, j}
 		}
 	}
 	rng.Shuffle(len(all_pos_low), func(i, j int) {
@@ -294,10 +460,11 @@ func make_initial_game() Game {
 			} else {
 				pos = all_pos_high[i]
 			}
+			side := sides[owner(game.Board, pos[0])]
 			game.Board[pos[0]][pos[1]].Type = ELEMENTAL
-			game.Board[pos[0]][pos[1]].Element = elements[rng.Intn(num_elements_per_player)+2*(pos[0]/6)]
-			game.Board[pos[0]][pos[1]].Level = LEVELS[0]
-			game.Board[pos[0]][pos[1]].Health = HEALTH[0]
+			game.Board[pos[0]][pos[1]].Element = side[rng.Intn(len(side))]
+			game.Board[pos[0]][pos[1]].Level = 1
+			game.Board[pos[0]][pos[1]].Health = rules.Health[0]
 		}
 	}
 
@@ -305,80 +472,18 @@ func make_initial_game() Game {
 	// >>>
 }
 
-var merge_configurations = [8][3][2]int{
-	// <<<
-	{{-1, -1}, {+0, -1}, {+1, -1}},
-	{{-1, +0}, {+0, +0}, {+1, +0}},
-	{{-1, +1}, {+0, +1}, {+1, +1}},
-	{{-1, -1}, {-1, +0}, {-1, +1}},
-	{{+0, -1}, {+0, +0}, {+0, +1}},
-	{{+1, -1}, {+1, +0}, {+1, +1}},
-	{{-1, -1}, {+0, +0}, {+1, +1}},
-	{{+1, -1}, {+0, +0}, {-1, +1}},
-	// >>>
-}
-
-// offset ::= 0 | 1
-func merge_board(board *Board, offset int) int {
-	// <<<
-	new_charges := 0
-	next := board
-	todo := [SIZE / 2][SIZE]byte{} // 0=nop << 1=remove << 2=ascend ; low_priority << high_priority
-	o := offset * SIZE / 2
-
-	for row := 1; row < SIZE/2-1; row++ {
-		for col := 1; col < SIZE-1; col++ {
-			for i := 0; i < len(merge_configurations); i++ {
-				conf := merge_configurations[i]
-				a := board[row+conf[0][1]+o][col+conf[0][0]]
-				b := board[row+conf[1][1]+o][col+conf[1][0]]
-				c := board[row+conf[2][1]+o][col+conf[2][0]]
-				if !(a.Type == ELEMENTAL && a.Type == b.Type && b.Type == c.Type &&
-					a.Element == b.Element && b.Element == c.Element &&
-					a.Level == b.Level && b.Level == c.Level &&
-					(a.Level == 1 || a.Level == 2)) {
-					continue
-				}
-				todo[row+conf[0][1]][col+conf[0][0]] |= 0b01
-				todo[row+conf[1][1]][col+conf[1][0]] |= 0b10
-				todo[row+conf[2][1]][col+conf[2][0]] |= 0b01
-			}
-		}
-	}
-
-	for row := 0; row < SIZE/2; row++ {
-		for col := 0; col < SIZE; col++ {
-			switch todo[row][col] {
-			case 0:
-				break
-			case 1:
-				next[row+o][col].Type = EMPTY
-			case 2:
-				fallthrough
-			case 3:
-				next[row+o][col].Health = HEALTH[board[row+o][col].Level]
-				new_charges += next[row+o][col].Level
-				next[row+o][col].Level += 1
-			}
-		}
-	}
-
-	board = next
-	return new_charges
-	// >>>
-}
-
 func block_board(game *Game) {
 	// <<<
 	if game.Turn%2 != 0 {
 		return
 	}
+	size := len(game.Board)
 
 	move := func(row, col int) {
 		if game.Board[row][col].Type == ELEMENTAL {
 			empty := [2]int{-1, col}
-			if row < SIZE/2 {
-				for i := row + 1; i < SIZE/2; i++ {
+			if row < size/2 {
+				for i := row + 1; i < size/2; i++ {
 					if game.Board[i][col].Type != ELEMENTAL {
 						empty[0] = i
 						break
@@ -393,7 +498,7 @@ func block_board(game *Game) {
 				}
 			}
 			if empty[0] != -1 {
-				if row < SIZE/2 { // empty > row
+				if row < size/2 { // empty > row
 					for i := empty[0]; i > row; i-- {
 						game.Board[i][col] = game.Board[i-1][col]
 					}
@@ -404,35 +509,37 @@ func block_board(game *Game) {
 				}
 			}
 		}
-		game.Board[row][col].Type = BLOCK
-		game.Board[row][col].Element = ""
-		game.Board[row][col].Level = 0
-		game.Board[row][col].Health = 0
+		game.Board[row][col] = Cell{Type: BLOCK}
 	}
 
 	i := game.Turn/2 - 1
-	r := i / (SIZE / 2)
-	c := i % (SIZE / 2)
+	r := i / (size / 2)
+	c := i % (size / 2)
+	if r >= size {
+		return
+	}
 	move(r, c)
-	move(r, SIZE-1-c)
-	move(SIZE-1-r, c)
-	move(SIZE-1-r, (SIZE-1)-c)
+	move(r, size-1-c)
+	move(size-1-r, c)
+	move(size-1-r, (size-1)-c)
 	// >>>
 }
 
-func apply_damage(game *Game, to_row, to_col, damage int) {
+func apply_damage(rules Ruleset, game *Game, to_row, to_col, damage int) {
 	// <<<
 	to_cell := game.Board[to_row][to_col]
+	if damage > 0 && has_effect(to_cell, SHIELD) {
+		to_cell.Status[SHIELD] = 0
+		damage = 0
+	}
+	damage = armor(rules, to_cell, damage)
 	to_cell.Health -= damage
 	if to_cell.Health <= 0 {
 		to_cell.Level -= 1
 		if to_cell.Level <= 0 {
-			to_cell.Type = EMPTY
-			to_cell.Element = ""
-			to_cell.Health = 0
-			to_cell.Level = 0
+			to_cell = Cell{Type: EMPTY}
 		} else {
-			to_cell.Health = HEALTH[to_cell.Level-1]
+			to_cell.Health = rules.Health[to_cell.Level-1]
 		}
 	}
 	game.Board[to_row][to_col] = to_cell
@@ -441,11 +548,13 @@ func apply_damage(game *Game, to_row, to_col, damage int) {
 
 func advance_turn(gw *GameWrapper) {
 	// <<<
-	new_charges := merge_board(&gw.Game.Board, gw.Game.Turn%2)
+	new_charges, steps := cascade_board(gw.Rules, &gw.Game.Board, 1-gw.Game.ActivePlayer) // the half of the active player
+	gw.Cascade = Cascade{Action: len(gw.History) + 1, Seat: gw.Game.ActivePlayer, Steps: steps}
 	if new_charges > 0 {
-		for i := 0; i < len(SPELLS); i++ {
+		charges := loadout_charges(gw.Rules, gw.Game.Loadouts[gw.Game.ActivePlayer])
+		for i := range charges {
 			gw.Game.Players[gw.Game.ActivePlayer][i] = clamp(
-				gw.Game.Players[gw.Game.ActivePlayer][i]+new_charges, 0, CHARGES[i],
+				gw.Game.Players[gw.Game.ActivePlayer][i]+new_charges, 0, charges[i],
 			)
 		}
 	}
@@ -453,6 +562,8 @@ func advance_turn(gw *GameWrapper) {
 		gw.SkipAdvance -= 1
 		return
 	}
+	apply_traits(gw.Rules, &gw.Game, gw.Game.ActivePlayer)
+	tick_effects(gw.Rules, &gw.Game, gw.Game.ActivePlayer)
 	block_board(&gw.Game)
 	gw.Game.Turn += 1
 	gw.PlayerCanUseSpell[gw.Game.ActivePlayer] = true
@@ -460,38 +571,39 @@ func advance_turn(gw *GameWrapper) {
 	// >>>
 }
 
-func able_to_attack(board Board, row, col int) bool {
+func able_to_attack(rules Ruleset, board Board, row, col int) bool {
 	// <<<
 	cell := board[row][col]
+	size := len(board)
 
-	if cell.Type != ELEMENTAL {
+	if cell.Type != ELEMENTAL || has_effect(cell, STUN) {
 		return false
 	}
 
-	r := REACH[cell.Level-1]
-	col_a := clamp(col-1, 0, SIZE-1)
-	col_b := clamp(col+1, 0, SIZE-1)
+	r, c := reach(rules, cell), col_reach(rules, cell)
+	col_a := clamp(col-c, 0, size-1)
+	col_b := clamp(col+c, 0, size-1)
 	row_a, row_b := row, row
-	if row < SIZE/2 {
+	if row < size/2 {
 		row_a += 1
 		row_b += r
-		if row_b < SIZE/2 {
+		if row_b < size/2 {
 			return false
 		}
-	} else { // row >= BOARD_SIZE/2
+	} else { // row >= size/2
 		row_a -= r
 		row_b -= 1
-		if row_a >= SIZE/2 {
+		if row_a >= size/2 {
 			return false
 		}
 	}
-	row_a = clamp(row_a, 0, SIZE-1)
-	row_b = clamp(row_b, 0, SIZE-1)
+	row_a = clamp(row_a, 0, size-1)
+	row_b = clamp(row_b, 0, size-1)
 	// log.Printf("%+v # row/col %v/%v : %v-%v %v-%v\n\n\n", aos2soaB(board), row, col, row_a, row_b, col_a, col_b)
 
 	for i := row_a; i <= row_b; i++ {
 		for j := col_a; j <= col_b; j++ {
-			if sign(row-SIZE/2) != sign(i-SIZE/2) && board[i][j].Type == ELEMENTAL {
+			if sign(row-size/2) != sign(i-size/2) && board[i][j].Type == ELEMENTAL {
 				return true
 			}
 		}
@@ -500,15 +612,19 @@ func able_to_attack(board Board, row, col int) bool {
 	return false
 	// >>>
 }
-func can_attack(board Board, from_row, from_col, to_row, to_col int) bool {
+func can_attack(rules Ruleset, board Board, from_row, from_col, to_row, to_col int) bool {
 	// <<<
 	if board[from_row][from_col].Type != ELEMENTAL || board[to_row][to_col].Type != ELEMENTAL {
 		return false
 	}
+	if has_effect(board[from_row][from_col], STUN) {
+		return false
+	}
 
-	r := REACH[board[from_row][from_col].Level-1]
-	if sign(from_row-SIZE/2) == sign(to_row-SIZE/2) ||
-		abs(from_col-to_col) > 1 || abs(from_row-to_row) > r {
+	r := reach(rules, board[from_row][from_col])
+	size := len(board)
+	if sign(from_row-size/2) == sign(to_row-size/2) ||
+		abs(from_col-to_col) > col_reach(rules, board[from_row][from_col]) || abs(from_row-to_row) > r {
 		return false
 	}
 
@@ -516,67 +632,519 @@ func can_attack(board Board, from_row, from_col, to_row, to_col int) bool {
 	// >>>
 }
 
+// player 0 owns the lower half (row >= size/2), player 1 the upper one
+func owner(board Board, row int) int {
+	// <<<
+	if row >= len(board)/2 {
+		return 0
+	}
+	return 1
+	// >>>
+}
+
+// winner ::= -1 (draw) | 0 | 1 ; only meaningful when over == true
+func game_winner(game Game) (over bool, winner int) {
+	// <<<
+	alive := [2]int{}
+	for i := range game.Board {
+		for j := range game.Board[i] {
+			if game.Board[i][j].Type == ELEMENTAL {
+				alive[owner(game.Board, i)] += 1
+			}
+		}
+	}
+
+	switch {
+	case alive[0] == 0 && alive[1] == 0:
+		return true, -1
+	case alive[0] == 0:
+		return true, 1
+	case alive[1] == 0:
+		return true, 0
+	}
+	return false, -1
+	// >>>
+}
+
+// in hotseat lobbies the only player sits on the seat of the active player
+func seat_of(gw GameWrapper, player_id string) int {
+	// <<<
+	if player_id == "" {
+		return -1
+	}
+	if gw.Options.Hotseat && gw.Owner == player_id {
+		return gw.Game.ActivePlayer
+	}
+	return slices.Index(gw.Players, player_id)
+	// >>>
+}
+
+func seated(gw GameWrapper) bool {
+	return len(gw.Players) == 2 && gw.Players[0] != "" && gw.Players[1] != ""
+}
+
+// winner ::= -1 (draw) | 0 | 1 ; forfeits take precedence over the board
+func game_result(gw GameWrapper) (over bool, winner int) {
+	// <<<
+	switch {
+	case gw.Forfeit[0] && gw.Forfeit[1]:
+		return true, -1
+	case gw.Forfeit[0]:
+		return true, 1
+	case gw.Forfeit[1]:
+		return true, 0
+	case drafting(gw), placing(gw), choosing(gw):
+		return false, -1
+	}
+	return game_winner(gw.Game)
+	// >>>
+}
+
+func can_act(gw GameWrapper, player_index int) bool {
+	// <<<
+	over, _ := game_result(gw)
+	return seated(gw) && !over && !drafting(gw) && !placing(gw) && !choosing(gw) && player_index == gw.Game.ActivePlayer
+	// >>>
+}
+
+// the caller is responsible for checking can_act first
+func apply_action(gw *GameWrapper, player_index int, action Action) error {
+	// <<<
+	to := action.To
+	from := action.From
+
+	switch action.Type {
+	case SKIP:
+		advance_turn(gw)
+	case SPELL:
+		if !gw.PlayerCanUseSpell[player_index] {
+			return fmt.Errorf("Only one spell per turn.")
+		}
+		err := apply_spell(gw, player_index, action)
+		if err != nil {
+			return err
+		}
+		gw.PlayerCanUseSpell[player_index] = false
+	case MOVE:
+		if !valid(gw.Game.Board, to.Row, to.Col) || !valid(gw.Game.Board, from.Row, from.Col) {
+			return fmt.Errorf("Invalid Cell")
+		}
+		if owner(gw.Game.Board, from.Row) != player_index {
+			return fmt.Errorf("Can move only one's own elementals.")
+		}
+		if sign(from.Row-len(gw.Game.Board)/2) != sign(to.Row-len(gw.Game.Board)/2) {
+			return fmt.Errorf("Can move only within one's own borders.")
+		}
+		gw.Game.Board[to.Row][to.Col], gw.Game.Board[from.Row][from.Col] =
+			gw.Game.Board[from.Row][from.Col], gw.Game.Board[to.Row][to.Col]
+		if !able_to_attack(gw.Rules, gw.Game.Board, to.Row, to.Col) {
+			advance_turn(gw)
+		}
+	case ATTACK:
+		if !valid(gw.Game.Board, to.Row, to.Col) || !valid(gw.Game.Board, from.Row, from.Col) {
+			return fmt.Errorf("Invalid Cell")
+		}
+		if has_effect(gw.Game.Board[from.Row][from.Col], STUN) {
+			return fmt.Errorf("The elemental is stunned.")
+		}
+		if !can_attack(gw.Rules, gw.Game.Board, from.Row, from.Col, to.Row, to.Col) {
+			return fmt.Errorf("Can attack only the enemy's elementals.")
+		}
+		attack(gw.Rules, &gw.Game, from, to)
+		advance_turn(gw)
+	default:
+		return fmt.Errorf("Invalid Action")
+	}
+
+	return nil
+	// >>>
+}
+
 // =============================================================================
 
-func handle_join(w http.ResponseWriter, r *http.Request) {
+func add_lobby(gw GameWrapper) string {
+	games.Lock()
+	defer games.Unlock()
+	return store_lobby(gw)
+}
+
+// games must be locked
+func store_lobby(gw GameWrapper) string {
 	// <<<
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	lobby_id := strings.ToUpper(make_id(6))
+	gw.CreatedAt = time.Now()
+	gw.LastAccessedAt = time.Now()
+	if gw.Start == "" {
+		gw.Start = format_position(gw.Game, gw.SkipAdvance)
+	}
+
+	for _, taken := games.m[lobby_id]; taken; _, taken = games.m[lobby_id] {
+		lobby_id = strings.ToUpper(make_id(6))
+	}
+	games.m[lobby_id] = gw
+
+	return lobby_id
+	// >>>
+}
+
+func validate_options(options LobbyOptions) error {
+	// <<<
+	switch options.First {
+	case "", FIRST_CREATOR, FIRST_OPPONENT, FIRST_RANDOM:
+	default:
+		return fmt.Errorf("invalid first player %q", options.First)
+	}
+	switch options.Side {
+	case "", BOTTOM, TOP:
+	default:
+		return fmt.Errorf("invalid side %q", options.Side)
+	}
+	switch options.BestOf {
+	case 0, 1, 3, 5, 7:
+	default:
+		return fmt.Errorf("best of must be 1, 3, 5 or 7")
+	}
+	switch options.Layout {
+	case "", LAYOUT_RANDOM, LAYOUT_MIRROR, LAYOUT_ROTATE:
+	default:
+		return fmt.Errorf("invalid layout %q", options.Layout)
+	}
+	if options.Placement && (options.Layout != "" || options.Fair) {
+		return fmt.Errorf("layouts do not apply to placements")
+	}
+	if _, err := find_ruleset(options.Rules); err != nil {
+		return err
+	}
+	return nil
+	// >>>
+}
+
+// seats the owner on the side of options, the other seat stays open unless
+// playing hotseat
+func make_lobby(game Game, owner string, options LobbyOptions) GameWrapper {
+	// <<<
+	creator := 0
+	if options.Side == TOP {
+		creator = 1
+	}
+	switch options.First {
+	case FIRST_CREATOR:
+		game.ActivePlayer = creator
+	case FIRST_OPPONENT:
+		game.ActivePlayer = 1 - creator
+	case FIRST_RANDOM:
+		game.ActivePlayer = rand.Intn(2)
+	}
+
+	gw := GameWrapper{
+		Game:              game,
+		Rules:             options_ruleset(options),
+		Players:           []string{"", ""},
+		PlayerCanUseSpell: []bool{true, true},
+		Owner:             owner,
+		Tokens:            [2]string{make_token(24), make_token(24)},
+		LastSeen:          [2]time.Time{time.Now(), time.Now()},
+		Options:           options,
+		Creator:           creator,
+		First:             game.ActivePlayer,
+		Series:            Series{BestOf: max(options.BestOf, 1), Number: 1},
+	}
+	gw.Players[creator] = owner
+	if options.Hotseat {
+		gw.Players[1-creator] = owner
+	}
+	return gw
+	// >>>
+}
+
+// an empty board while the elements are drafted or the elementals placed
+func make_lobby_game(seed int64, options LobbyOptions) Game {
+	// <<<
+	rules := options_ruleset(options)
+	if options.Draft || options.Placement {
+		return make_empty_game(rules)
+	}
+	rng := rand.New(rand.NewSource(seed))
+	return generate_game(rules, rng, random_sides(rng), options.Layout, options.Fair)
+	// >>>
+}
+
+func new_lobby(player_id string, options LobbyOptions) (string, GameWrapper) {
+	// <<<
+	seed := time.Now().UnixNano()
+	gw := make_lobby(make_lobby_game(seed, options), player_id, options)
+	gw.Seed = seed
+	if options.Placement && !options.Draft {
+		start_placement(&gw, random_sides(rand.New(rand.NewSource(seed))))
+	}
+	lobby_id := add_lobby(gw)
+	return lobby_id, gw
+	// >>>
+}
+
+func touch(gw *GameWrapper, player_id string) {
+	// <<<
+	gw.LastAccessedAt = time.Now()
+	if seat := seat_of(*gw, player_id); seat != -1 {
+		gw.LastSeen[seat] = time.Now()
+	}
+	// >>>
+}
+
+// a seated player who has not been seen for longer than the grace period
+// forfeits, hotseat lobbies have nobody to forfeit to
+func check_forfeit(gw *GameWrapper) {
+	// <<<
+	if *forfeit_grace <= 0 || gw.Options.Hotseat || !seated(*gw) {
 		return
 	}
+	if over, _ := game_result(*gw); over {
+		return
+	}
+	for seat := 0; seat < 2; seat++ {
+		if time.Since(gw.LastSeen[seat]) > *forfeit_grace {
+			gw.Forfeit[seat] = true
+		}
+	}
+	// >>>
+}
 
-	var data struct {
-		PlayerID string `json:"player_id"`
-		LobbyID  string `json:"lobby_id"`
+func series_decided(series Series) bool {
+	return 2*series.Score[0] > series.BestOf || 2*series.Score[1] > series.BestOf
+}
+
+// the next game for the same players on swapped sides, a decided series
+// starts over, games must be locked
+func start_rematch(gw *GameWrapper) string {
+	// <<<
+	if gw.Next != "" {
+		return gw.Next
 	}
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+
+	options := gw.Options
+	options.Side = TOP
+	if gw.Creator == 1 {
+		options.Side = BOTTOM
+	}
+	seed := time.Now().UnixNano()
+	next := make_lobby(make_lobby_game(seed, options), gw.Owner, options)
+	next.Seed = seed
+	if options.Placement && !options.Draft {
+		start_placement(&next, random_sides(rand.New(rand.NewSource(seed))))
+	}
+	next.Players = []string{gw.Players[1], gw.Players[0]}
+	next.Tokens = [2]string{gw.Tokens[1], gw.Tokens[0]}
+	next.Series = Series{
+		BestOf: gw.Series.BestOf,
+		Number: gw.Series.Number + 1,
+		Score:  [2]int{gw.Series.Score[1], gw.Series.Score[0]},
+	}
+	if series_decided(gw.Series) {
+		next.Series.Number, next.Series.Score = 1, [2]int{}
+	}
+
+	gw.Next = store_lobby(next)
+	return gw.Next
+	// >>>
+}
+
+// time based changes of a lobby, games must be locked
+func update_lobby(gw *GameWrapper) {
+	// <<<
+	check_forfeit(gw)
+	check_placement(gw)
+	finish_game(gw)
+	// >>>
+}
+
+// counts the result of a game which is over once and starts the next game of
+// an undecided series, games must be locked
+func finish_game(gw *GameWrapper) {
+	// <<<
+	over, winner := game_result(*gw)
+	if !over || gw.Finished {
 		return
 	}
-	// log.Printf("Join Lobby Request: %+v\n", pretty_print(data))
+	gw.Finished = true
+	if winner != -1 {
+		gw.Series.Score[winner] += 1
+	}
+	if gw.Series.BestOf > 1 && !series_decided(gw.Series) {
+		start_rematch(gw)
+	}
+	// >>>
+}
+
+func reconnect_token(gw GameWrapper, player_id string) string {
+	// <<<
+	seat := seat_of(gw, player_id)
+	if seat == -1 {
+		return ""
+	}
+	return gw.Tokens[seat]
+	// >>>
+}
+
+// reading a lobby counts as being present in it
+func read_lobby(lobby_id, player_id string) (GameWrapper, bool) {
+	// <<<
+	games.Lock()
+	defer games.Unlock()
 
-	lobby_id := data.LobbyID
-	games.RLock()
 	gw, ok := games.m[lobby_id]
-	games.RUnlock()
+	if ok {
+		touch(&gw, player_id)
+		update_lobby(&gw)
+		games.m[lobby_id] = gw
+	}
+	return gw, ok
+	// >>>
+}
+
+// joined reports whether the player took a new seat
+func join_lobby(lobby_id, player_id string) (gw GameWrapper, joined bool, err error) {
+	// <<<
+	games.Lock()
+	defer games.Unlock()
 
+	gw, ok := games.m[lobby_id]
 	if !ok {
-		http.Error(w, "Invalid Lobby ID", http.StatusBadRequest)
-		return
+		return gw, false, fmt.Errorf("Invalid Lobby ID")
 	}
 
-	if len(gw.Players) >= 2 && !slices.Contains(gw.Players, data.PlayerID) {
-		http.Error(w, "Full Lobby", http.StatusBadRequest)
-		return
+	touch(&gw, player_id)
+	update_lobby(&gw)
+	if seat_of(gw, player_id) == -1 {
+		seat := slices.Index(gw.Players, "")
+		if seat == -1 || player_id == "" {
+			return gw, false, fmt.Errorf("Full Lobby")
+		}
+		gw.Players = slices.Clone(gw.Players) // readers may still hold the stored players
+		gw.Players[seat] = player_id
+		gw.LastSeen = [2]time.Time{time.Now(), time.Now()} // the clock starts once both are seated
+		joined = true
 	}
 
-	player_index := slices.Index(gw.Players, data.PlayerID)
+	games.m[lobby_id] = gw
+	return gw, joined, nil
+	// >>>
+}
 
-	gw.LastAccessedAt = time.Now()
+// ok is false when it is not the player's turn, the game is returned either way
+func act(lobby_id, player_id string, action Action) (gw GameWrapper, ok bool, err error) {
+	// <<<
 	games.Lock()
-	games.m[lobby_id] = gw
-	games.Unlock()
+	defer games.Unlock()
+
+	gw, found := games.m[lobby_id]
+	if !found {
+		return gw, false, fmt.Errorf("Invalid Lobby ID")
+	}
 
-	if !slices.Contains(gw.Players, data.PlayerID) {
-		gw.Players = append(gw.Players, data.PlayerID)
-		gw.PlayerCanUseSpell = append(gw.PlayerCanUseSpell, true)
-		games.Lock()
+	touch(&gw, player_id) // before update_lobby, so acting in time does not forfeit
+	update_lobby(&gw)
+	if over, _ := game_result(gw); over {
 		games.m[lobby_id] = gw
-		games.Unlock()
-		player_index = len(gw.Players)
+		return gw, false, fmt.Errorf("The game is over")
 	}
+	if drafting(gw) {
+		games.m[lobby_id] = gw
+		return gw, false, fmt.Errorf("The draft is not over")
+	}
+	if placing(gw) {
+		games.m[lobby_id] = gw
+		return gw, false, fmt.Errorf("The placement is not over")
+	}
+	if choosing(gw) {
+		games.m[lobby_id] = gw
+		return gw, false, fmt.Errorf("The loadouts are not chosen yet")
+	}
+
+	player_index := seat_of(gw, player_id)
+	ok = can_act(gw, player_index) && slices.Index(ACTION_TYPES, action.Type) != -1
+
+	if ok {
+		// readers may still hold the stored board
+		gw.Game.Board = copy_board(gw.Game.Board)
+		gw.PlayerCanUseSpell = slices.Clone(gw.PlayerCanUseSpell)
+		err = apply_action(&gw, player_index, action)
+		if err != nil {
+			return gw, false, err
+		}
+		gw.History = append(gw.History, action)
+		finish_game(&gw)
+	}
+
+	games.m[lobby_id] = gw
+	return gw, ok, nil
+	// >>>
+}
+
+func rematch(lobby_id, player_id string) (next_id string, next GameWrapper, err error) {
+	// <<<
+	games.Lock()
+	defer games.Unlock()
+
+	gw, found := games.m[lobby_id]
+	if !found {
+		return "", next, fmt.Errorf("Invalid Lobby ID")
+	}
+	if seat_of(gw, player_id) == -1 {
+		return "", next, fmt.Errorf("Only the players can ask for a rematch")
+	}
+	if over, _ := game_result(gw); !over {
+		return "", next, fmt.Errorf("The game is not over")
+	}
+
+	finish_game(&gw)
+	next_id = start_rematch(&gw)
+	games.m[lobby_id] = gw
+	return next_id, games.m[next_id], nil
+	// >>>
+}
+
+// =============================================================================
+
+func handle_join(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		PlayerID string `json:"player_id"`
+		LobbyID  string `json:"lobby_id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+	// log.Printf("Join Lobby Request: %+v\n", pretty_print(data))
 
-	// log.Printf("Lobby: %v, Players: %+v", lobby_id, gw.Players)
+	gw, _, err := join_lobby(data.LobbyID, data.PlayerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// log.Printf("Lobby: %v, Players: %+v", data.LobbyID, gw.Players)
 
 	response := struct {
-		Ok          bool    `json:"ok"`
-		GameSOA     GameSOA `json:"game_soa"`
-		PlayerIndex int     `json:"player_index"`
+		Ok             bool      `json:"ok"`
+		GameSOA        GameSOA   `json:"game_soa"`
+		PlayerIndex    int       `json:"player_index"`
+		Hotseat        bool      `json:"hotseat"`
+		ReconnectToken string    `json:"reconnect_token"`
+		Rules          RulesInfo `json:"rules"`
 	}{
-		Ok:          ok,
-		GameSOA:     aos2soa(gw.Game),
-		PlayerIndex: player_index,
+		Ok:             true,
+		GameSOA:        aos2soa(gw.Game),
+		PlayerIndex:    seat_of(gw, data.PlayerID),
+		Hotseat:        gw.Options.Hotseat,
+		ReconnectToken: reconnect_token(gw, data.PlayerID),
+		Rules:          rules_info(gw.Rules),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -592,7 +1160,8 @@ func handle_new_lobby(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var data struct {
-		PlayerID string `json:"player_id"`
+		PlayerID string       `json:"player_id"`
+		Options  LobbyOptions `json:"options"`
 	}
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
@@ -600,25 +1169,27 @@ func handle_new_lobby(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// log.Printf("New Lobby Request: %+v\n", pretty_print(data))
-
-	lobby_id := strings.ToUpper(make_id(6))
-	game := make_initial_game()
-	games.Lock()
-	games.m[lobby_id] = GameWrapper{
-		Game:              game,
-		Players:           []string{data.PlayerID},
-		PlayerCanUseSpell: []bool{true},
-		CreatedAt:         time.Now(),
-		LastAccessedAt:    time.Now(),
+	if err := validate_options(data.Options); err != nil {
+		http.Error(w, "Invalid Options: "+err.Error(), http.StatusBadRequest)
+		return
 	}
-	games.Unlock()
+
+	lobby_id, gw := new_lobby(data.PlayerID, data.Options)
 
 	response := struct {
-		LobbyID string  `json:"lobby_id"`
-		GameSOA GameSOA `json:"game_soa"`
+		LobbyID        string    `json:"lobby_id"`
+		GameSOA        GameSOA   `json:"game_soa"`
+		PlayerIndex    int       `json:"player_index"`
+		Hotseat        bool      `json:"hotseat"`
+		ReconnectToken string    `json:"reconnect_token"`
+		Rules          RulesInfo `json:"rules"`
 	}{
-		LobbyID: lobby_id,
-		GameSOA: aos2soa(game),
+		LobbyID:        lobby_id,
+		GameSOA:        aos2soa(gw.Game),
+		PlayerIndex:    seat_of(gw, data.PlayerID),
+		Hotseat:        gw.Options.Hotseat,
+		ReconnectToken: reconnect_token(gw, data.PlayerID),
+		Rules:          rules_info(gw.Rules),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -655,18 +1226,7 @@ func handle_action(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		LobbyID  string `json:"lobby_id"`
 		PlayerID string `json:"player_id"`
-		Action   struct {
-			Type  ActionType `json:"type"`  // skip == other fields are ignored
-			Spell Spell      `json:"spell"` // empty means none was used
-			From  struct {
-				Row int `json:"row"`
-				Col int `json:"col"`
-			} `json:"from"`
-			To struct {
-				Row int `json:"row"`
-				Col int `json:"col"`
-			} `json:"to"`
-		} `json:"action"`
+		Action   Action `json:"action"`
 	}
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
@@ -675,138 +1235,137 @@ func handle_action(w http.ResponseWriter, r *http.Request) {
 	}
 	// log.Printf("Action Request: %+v\n", pretty_print(data))
 
-	games.RLock()
+	gw, valid_action, err := act(data.LobbyID, data.PlayerID, data.Action)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := struct {
+		Ok          bool     `json:"ok"`
+		GameSOA     GameSOA  `json:"game_soa"`
+		Perspective int      `json:"perspective"` // the seat to render the board for
+		Result      string   `json:"result"`
+		Cascade     *Cascade `json:"cascade"` // null unless the action ended in merges
+	}{
+		Ok:          valid_action,
+		GameSOA:     aos2soa(gw.Game),
+		Perspective: seat_of(gw, data.PlayerID),
+		Result:      result_text(gw),
+		Cascade:     last_cascade(gw),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	// >>>
+}
+
+func handle_reconnect(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		LobbyID  string `json:"lobby_id"`
+		PlayerID string `json:"player_id"` // the id to sit on the seat from now on
+		Token    string `json:"reconnect_token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	games.Lock()
 	gw, ok := games.m[data.LobbyID]
-	games.RUnlock()
+	seat := -1
+	if ok && data.Token != "" && data.PlayerID != "" {
+		seat = slices.Index(gw.Tokens[:], data.Token)
+	}
+	if seat != -1 {
+		gw.Players = slices.Clone(gw.Players) // readers may still hold the stored players
+		previous := gw.Players[seat]
+		for i := range gw.Players {
+			if gw.Players[i] == previous && previous != "" {
+				gw.Players[i] = data.PlayerID // both seats in hotseat lobbies
+			}
+		}
+		if gw.Owner == previous {
+			gw.Owner = data.PlayerID
+		}
+		gw.Players[seat] = data.PlayerID
+		touch(&gw, data.PlayerID)
+		games.m[data.LobbyID] = gw
+	}
+	games.Unlock()
 
 	if !ok {
 		http.Error(w, "Invalid Lobby ID", http.StatusBadRequest)
 		return
 	}
+	if seat == -1 {
+		http.Error(w, "Invalid Reconnect Token", http.StatusBadRequest)
+		return
+	}
 
-	valid_action := len(gw.Players) == 2 &&
-		slices.Index(gw.Players, data.PlayerID) == gw.Game.ActivePlayer &&
-		slices.Index(ACTION_TYPES, data.Action.Type) != -1
+	response := struct {
+		Ok          bool      `json:"ok"`
+		GameSOA     GameSOA   `json:"game_soa"`
+		PlayerIndex int       `json:"player_index"`
+		Hotseat     bool      `json:"hotseat"`
+		Rules       RulesInfo `json:"rules"`
+	}{
+		Ok:          true,
+		GameSOA:     aos2soa(gw.Game),
+		PlayerIndex: seat,
+		Hotseat:     gw.Options.Hotseat,
+		Rules:       rules_info(gw.Rules),
+	}
 
-	player_index := slices.Index(gw.Players, data.PlayerID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	// >>>
+}
 
-	if valid_action {
-		switch data.Action.Type {
-		case SKIP:
-			advance_turn(&gw)
-		case SPELL:
-			switch data.Action.Spell {
-			case FS:
-				to := data.Action.To
-				if !valid(to.Row, to.Col) || gw.Game.ActivePlayer != (sign(to.Row-SIZE/2)+1)/2 {
-					http.Error(w, "Invalid Cell", http.StatusBadRequest)
-					return
-				}
-				spell_index := slices.Index(SPELLS, FS)
-				gw.Game.Players[player_index][spell_index] = 0
-
-				apply_damage(&gw.Game, to.Row, to.Col, SPELL_DAMAGE[spell_index])
-			case HV:
-				to := data.Action.To
-				if !valid(to.Row, to.Col) || gw.Game.ActivePlayer == (sign(to.Row-SIZE/2)+1)/2 {
-					http.Error(w, "Invalid Cell", http.StatusBadRequest)
-					return
-				}
-				spell_index := slices.Index(SPELLS, HV)
-				gw.Game.Players[player_index][spell_index] = 0
-				gw.Game.Board[to.Row][to.Col].Health = HEALTH[gw.Game.Board[to.Row][to.Col].Level-1]
-			case AF:
-				to := data.Action.To
-				if !valid(to.Row, to.Col) || gw.Game.ActivePlayer != (sign(to.Row-SIZE/2)+1)/2 {
-					http.Error(w, "Invalid Cell", http.StatusBadRequest)
-					return
-				}
-				spell_index := slices.Index(SPELLS, AF)
-				gw.Game.Players[player_index][spell_index] = 0
+func handle_rematch(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
 
-				offset := max(0, SIZE/2*sign(to.Row-SIZE/2))
-				for i := 0; i < SIZE/2; i++ {
-					apply_damage(&gw.Game, i+offset, to.Col, SPELL_DAMAGE[spell_index])
-				}
-				for j := 0; j < SIZE; j++ {
-					if j == to.Col {
-						continue
-					}
-					apply_damage(&gw.Game, to.Row, j, SPELL_DAMAGE[spell_index])
-				}
-			case DT:
-				spell_index := slices.Index(SPELLS, DT)
-				gw.Game.Players[player_index][spell_index] = 0
-				gw.SkipAdvance = 1
-			case MS:
-				to := data.Action.To
-				if !valid(to.Row, to.Col) || gw.Game.ActivePlayer != (sign(to.Row-SIZE/2)+1)/2 {
-					http.Error(w, "Invalid Cell", http.StatusBadRequest)
-					return
-				}
-				spell_index := slices.Index(SPELLS, MS)
-				gw.Game.Players[player_index][spell_index] = 0
-
-				row_low := clamp(to.Row-1, 0, SIZE/2-1)
-				row_high := clamp(to.Row+1, 0, SIZE/2-1)
-				if gw.Game.ActivePlayer == 1 {
-					row_low = clamp(to.Row-1, SIZE/2, SIZE-1)
-					row_high = clamp(to.Row+1, SIZE/2, SIZE-1)
-				}
-				for i := row_low; i <= row_high; i++ {
-					for j := max(to.Col-1, 0); j <= min(to.Col+1, SIZE-1); j++ {
-						apply_damage(&gw.Game, i, j, SPELL_DAMAGE[spell_index])
-					}
-				}
-			}
-			break
-		case MOVE:
-			to := data.Action.To
-			from := data.Action.From
-			if !valid(to.Row, to.Col) || !valid(from.Row, from.Col) {
-				http.Error(w, "Invalid Cell", http.StatusBadRequest)
-				return
-			}
-			if sign(from.Row-SIZE/2) != sign(to.Row-SIZE/2) {
-				http.Error(w, "Can move only within one's own borders.", http.StatusBadRequest)
-				break
-			}
-			gw.Game.Board[to.Row][to.Col], gw.Game.Board[from.Row][from.Col] =
-				gw.Game.Board[from.Row][from.Col], gw.Game.Board[to.Row][to.Col]
-			if !able_to_attack(gw.Game.Board, to.Row, to.Col) {
-				advance_turn(&gw)
-			}
-		case ATTACK:
-			to := data.Action.To
-			from := data.Action.From
-			if !valid(to.Row, to.Col) || !valid(from.Row, from.Col) {
-				http.Error(w, "Invalid Cell", http.StatusBadRequest)
-				return
-			}
-			if !can_attack(gw.Game.Board, from.Row, from.Col, to.Row, to.Col) {
-				http.Error(w, "Can attack only the enemy's elementals.", http.StatusBadRequest)
-				break
-			}
-			apply_damage(&gw.Game, to.Row, to.Col, DAMAGE[gw.Game.Board[from.Row][from.Col].Level-1])
-			advance_turn(&gw)
-			break
-		default:
-			http.Error(w, "Invalid Action", http.StatusBadRequest)
-			return
-		}
+	var data struct {
+		LobbyID  string `json:"lobby_id"`
+		PlayerID string `json:"player_id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
 	}
 
-	gw.LastAccessedAt = time.Now()
-	games.Lock()
-	games.m[data.LobbyID] = gw
-	games.Unlock()
+	next_id, next, err := rematch(data.LobbyID, data.PlayerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	response := struct {
-		Ok      bool    `json:"ok"`
-		GameSOA GameSOA `json:"game_soa"`
+		LobbyID        string  `json:"lobby_id"`
+		GameSOA        GameSOA `json:"game_soa"`
+		PlayerIndex    int     `json:"player_index"`
+		Hotseat        bool    `json:"hotseat"`
+		ReconnectToken string  `json:"reconnect_token"`
+		Series         Series  `json:"series"`
 	}{
-		Ok:      valid_action,
-		GameSOA: aos2soa(gw.Game),
+		LobbyID:        next_id,
+		GameSOA:        aos2soa(next.Game),
+		PlayerIndex:    seat_of(next, data.PlayerID),
+		Hotseat:        next.Options.Hotseat,
+		ReconnectToken: reconnect_token(next, data.PlayerID),
+		Series:         next.Series,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -822,7 +1381,8 @@ func handle_read(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var data struct {
-		LobbyID string `json:"lobby_id"`
+		LobbyID  string `json:"lobby_id"`
+		PlayerID string `json:"player_id"` // optional, for perspective
 	}
 	err := json.NewDecoder(r.Body).Decode(&data)
 	if err != nil {
@@ -830,25 +1390,36 @@ func handle_read(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	games.RLock()
-	gw, ok := games.m[data.LobbyID]
-	games.RUnlock()
-
+	gw, ok := read_lobby(data.LobbyID, data.PlayerID)
 	if !ok {
 		http.Error(w, "Invalid Lobby ID", http.StatusBadRequest)
 		return
 	}
 
-	gw.LastAccessedAt = time.Now()
-	games.Lock()
-	games.m[data.LobbyID] = gw
-	games.Unlock()
 	response := struct {
-		Ok      bool    `json:"ok"`
-		GameSOA GameSOA `json:"game_soa"`
+		Ok          bool        `json:"ok"`
+		GameSOA     GameSOA     `json:"game_soa"`
+		Perspective int         `json:"perspective"` // -1 without a seated player_id
+		Result      string      `json:"result"`
+		Forfeit     [2]bool     `json:"forfeit"`
+		Series      Series      `json:"series"`
+		Next        string      `json:"next"`  // lobby id of the following game, "" until there is one
+		Draft       *DraftState `json:"draft"` // null without a draft going on
+		Placement   *Placement  `json:"placement"`
+		Loadout     *Loadout    `json:"loadout"` // null without loadouts to choose
+		Cascade     Cascade     `json:"cascade"`
 	}{
-		Ok:      ok,
-		GameSOA: aos2soa(gw.Game),
+		Ok:          ok,
+		GameSOA:     aos2soa(gw.Game),
+		Perspective: seat_of(gw, data.PlayerID),
+		Result:      result_text(gw),
+		Forfeit:     gw.Forfeit,
+		Series:      gw.Series,
+		Next:        gw.Next,
+		Draft:       draft_state(gw),
+		Placement:   placement_state(gw),
+		Loadout:     loadout_state(gw),
+		Cascade:     gw.Cascade,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -858,6 +1429,8 @@ func handle_read(w http.ResponseWriter, r *http.Request) {
 
 // =============================================================================
 
+var forfeit_grace = flag.Duration("grace", 5*time.Minute, "absence after which a seated player forfeits, 0 disables forfeits")
+
 var games = struct {
 	sync.RWMutex
 	m map[string]GameWrapper
@@ -867,12 +1440,37 @@ var games = struct {
 
 func main() {
 	// <<<
+	flag.Parse()
+
+	if *sim_flags.games > 0 {
+		if err := run_simulation(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	http.Handle("/", http.FileServer(http.Dir("./static")))
 	http.HandleFunc("/api/join", handle_join)
 	http.HandleFunc("/api/new/lobby", handle_new_lobby)
 	http.HandleFunc("/api/new/player", handle_new_player)
 	http.HandleFunc("/api/action", handle_action)
 	http.HandleFunc("/api/read", handle_read)
+	http.HandleFunc("/api/reconnect", handle_reconnect)
+	http.HandleFunc("/api/rematch", handle_rematch)
+	http.HandleFunc("/api/draft", handle_draft)
+	http.HandleFunc("/api/loadout", handle_loadout)
+	http.HandleFunc("/api/place", handle_place)
+	http.HandleFunc("/api/load", handle_load)
+	http.HandleFunc("/api/position", handle_position)
+	http.HandleFunc("/api/export", handle_export)
+	http.HandleFunc("/api/board.png", handle_board_png)
+	http.HandleFunc("/api/history.gif", handle_history_gif)
+	http.HandleFunc("/api/new/sandbox", handle_new_sandbox)
+	http.HandleFunc("/api/sandbox/edit", handle_sandbox_edit)
+	http.HandleFunc("/api/puzzles", handle_puzzles)
+	http.HandleFunc("/api/puzzle/new", handle_new_puzzle)
+	http.HandleFunc("/api/puzzle/solve", handle_solve_puzzle)
+	http.HandleFunc("/api/rules", handle_rules)
 
 	if false {
 		go func() {
@@ -890,7 +1488,7 @@ func main() {
 						keysToDelete = append(keysToDelete, k)
 					}
 				}
-                games.RUnlock()
+				games.RUnlock()
 
 				fmt.Printf("Cleaning up %v games...\n", len(keysToDelete))
 
@@ -898,7 +1496,7 @@ func main() {
 				for _, key := range keysToDelete {
 					delete(games.m, key)
 				}
-                games.Unlock()
+				games.Unlock()
 
 				fmt.Println("Cleanup complete.")
 			}
@@ -906,6 +1504,10 @@ func main() {
 		}()
 	}
 
+	if *tcp_addr != "" {
+		go serve_tcp(*tcp_addr)
+	}
+
 	log.Println("Server is running on http://localhost:6969")
 	log.Fatal(http.ListenAndServe(":6969", nil))
 	// >>>
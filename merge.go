@@ -0,0 +1,200 @@
+package main
+
+// Merges are found by MergeShapes of the ruleset, anchored at every cell of a
+// half so every match fully inside the half counts, edges included. Bigger
+// shapes are matched first and a match may not use a cell of a match of a
+// bigger shape. Matches of shapes of the same size may share cells, like
+// crossing lines or three in a row twice over four cells, which ascends two.
+//
+// At the end of a turn merges cascade, elementals which ascended may merge
+// again right away, and every further step of the cascade multiplies its
+// charges by the Combo of the ruleset.
+
+// what changed in one round of merges, for clients to animate cascades
+type MergeStep struct {
+	// <<<
+	Ascended []Pos `json:"ascended"`
+	Removed  []Pos `json:"removed"`
+	Combo    int   `json:"combo"`   // multiplier of the charges of the step
+	Charges  int   `json:"charges"` // with the combo
+	// >>>
+}
+
+// the merges at the end of the last turn
+type Cascade struct {
+	// <<<
+	Action int         `json:"action"` // number of the action in the history which caused it, from 1
+	Seat   int         `json:"seat"`   // whose half merged
+	Steps  []MergeStep `json:"steps"`
+	// >>>
+}
+
+// the cascade of the last action, nil when it did not merge
+func last_cascade(gw GameWrapper) *Cascade {
+	// <<<
+	if gw.Cascade.Action != len(gw.History) || len(gw.Cascade.Steps) == 0 {
+		return nil
+	}
+	return &gw.Cascade
+	// >>>
+}
+
+// cells of a merge as {col, row} offsets from the anchor, the cell at Ascend
+// levels up, the other ones are removed and Bonus charges come on top of the
+// level of the ascended elemental
+type MergeShape struct {
+	// <<<
+	Name   string   `json:"name"`
+	Cells  [][2]int `json:"cells"`
+	Ascend int      `json:"ascend"`
+	Bonus  int      `json:"bonus"`
+	// >>>
+}
+
+var ( // <<<
+	MERGE_LINES = []MergeShape{
+		{Name: "column", Cells: [][2]int{{0, 0}, {0, 1}, {0, 2}}, Ascend: 1},
+		{Name: "row", Cells: [][2]int{{0, 0}, {1, 0}, {2, 0}}, Ascend: 1},
+		{Name: "diagonal", Cells: [][2]int{{0, 0}, {1, 1}, {2, 2}}, Ascend: 1},
+		{Name: "antidiagonal", Cells: [][2]int{{2, 0}, {1, 1}, {0, 2}}, Ascend: 1},
+	}
+	MERGE_FOURS = []MergeShape{
+		{Name: "four in a column", Cells: [][2]int{{0, 0}, {0, 1}, {0, 2}, {0, 3}}, Ascend: 1, Bonus: 2},
+		{Name: "four in a row", Cells: [][2]int{{0, 0}, {1, 0}, {2, 0}, {3, 0}}, Ascend: 1, Bonus: 2},
+	}
+	MERGE_SQUARE = []MergeShape{
+		{Name: "square", Cells: [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}}, Ascend: 0, Bonus: 1},
+	}
+	MERGE_CORNERS = []MergeShape{ // the corner ascends
+		{Name: "corner", Cells: [][2]int{{0, 0}, {1, 0}, {0, 1}}, Ascend: 0},
+		{Name: "corner", Cells: [][2]int{{1, 0}, {0, 0}, {1, 1}}, Ascend: 0},
+		{Name: "corner", Cells: [][2]int{{0, 1}, {0, 0}, {1, 1}}, Ascend: 0},
+		{Name: "corner", Cells: [][2]int{{1, 1}, {1, 0}, {0, 1}}, Ascend: 0},
+	}
+) // >>>
+
+// the cells of shape at the anchor in half coordinates, false when one of
+// them is outside the half
+func shape_cells(size int, shape MergeShape, row, col int) ([]Pos, bool) {
+	// <<<
+	cells := make([]Pos, len(shape.Cells))
+	for i, d := range shape.Cells {
+		cells[i] = Pos{row + d[1], col + d[0]}
+		if cells[i].Row < 0 || cells[i].Row >= size/2 || cells[i].Col < 0 || cells[i].Col >= size {
+			return nil, false
+		}
+	}
+	return cells, true
+	// >>>
+}
+
+// marks the matches of shape in todo, the half starts at row o, and returns
+// their bonus charges
+func merge_shape(rules Ruleset, board Board, o int, shape MergeShape, todo [][]byte, claimed [][]int) int {
+	// <<<
+	size := len(board)
+	bonus := 0
+	for row := 0; row < size/2; row++ {
+		for col := 0; col < size; col++ {
+			cells, ok := shape_cells(size, shape, row, col)
+			if !ok {
+				continue
+			}
+			a := board[cells[0].Row+o][cells[0].Col]
+			match := a.Type == ELEMENTAL && a.Level < max_level(rules)
+			for _, p := range cells {
+				b := board[p.Row+o][p.Col]
+				match = match && b.Type == ELEMENTAL && b.Element == a.Element && b.Level == a.Level &&
+					claimed[p.Row][p.Col] <= len(cells)
+			}
+			if !match {
+				continue
+			}
+			for k, p := range cells {
+				claimed[p.Row][p.Col] = len(cells)
+				if k == shape.Ascend {
+					todo[p.Row][p.Col] |= 0b10
+				} else {
+					todo[p.Row][p.Col] |= 0b01
+				}
+			}
+			bonus += shape.Bonus
+		}
+	}
+	return bonus
+	// >>>
+}
+
+// one round of merges, offset ::= 0 | 1
+func merge_board(rules Ruleset, board *Board, offset int) int {
+	return merge_step(rules, board, offset).Charges
+}
+
+// one round of merges with the cells it changed, the combo is left to
+// cascade_board
+func merge_step(rules Ruleset, board *Board, offset int) MergeStep {
+	// <<<
+	step := MergeStep{Ascended: []Pos{}, Removed: []Pos{}, Combo: 1}
+	new_charges := 0
+	next := board
+	size := len(*board)
+	todo := make_grid[byte](size)[:size/2]   // 0=nop << 1=remove << 2=ascend ; low_priority << high_priority
+	claimed := make_grid[int](size)[:size/2] // cells of the biggest shape matching the cell
+	o := offset * size / 2
+
+	biggest := 0
+	for _, shape := range rules.MergeShapes {
+		biggest = max(biggest, len(shape.Cells))
+	}
+	for n := biggest; n > 0; n-- {
+		for _, shape := range rules.MergeShapes {
+			if len(shape.Cells) == n {
+				new_charges += merge_shape(rules, *board, o, shape, todo, claimed)
+			}
+		}
+	}
+
+	for row := 0; row < size/2; row++ {
+		for col := 0; col < size; col++ {
+			switch todo[row][col] {
+			case 0:
+				break
+			case 1:
+				(*next)[row+o][col] = Cell{Type: EMPTY}
+				step.Removed = append(step.Removed, Pos{row + o, col})
+			case 2:
+				fallthrough
+			case 3:
+				(*next)[row+o][col].Health = rules.Health[(*board)[row+o][col].Level]
+				new_charges += (*next)[row+o][col].Level
+				(*next)[row+o][col].Level += 1
+				step.Ascended = append(step.Ascended, Pos{row + o, col})
+			}
+		}
+	}
+
+	board = next
+	step.Charges = new_charges
+	return step
+	// >>>
+}
+
+// merges until nothing merges any more, the charges of every step are
+// multiplied by the combo of the ruleset for its place in the cascade
+func cascade_board(rules Ruleset, board *Board, offset int) (int, []MergeStep) {
+	// <<<
+	new_charges := 0
+	steps := []MergeStep{}
+	for {
+		step := merge_step(rules, board, offset)
+		if len(step.Ascended) == 0 && len(step.Removed) == 0 {
+			break
+		}
+		step.Combo = rules.Combo[min(len(steps), len(rules.Combo)-1)]
+		step.Charges *= step.Combo
+		new_charges += step.Charges
+		steps = append(steps, step)
+	}
+	return new_charges, steps
+	// >>>
+}
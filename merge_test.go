@@ -0,0 +1,206 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// a board of CLASSIC size with level 1 air elementals at cells, everything
+// else empty
+func merge_test_board(cells ...Pos) Board {
+	// <<<
+	board := make_board(CLASSIC.Size)
+	for i := range board {
+		for j := range board[i] {
+			board[i][j] = Cell{Type: EMPTY}
+		}
+	}
+	for _, p := range cells {
+		board[p.Row][p.Col] = Cell{Type: ELEMENTAL, Element: AIR, Level: 1, Health: CLASSIC.Health[0]}
+	}
+	return board
+	// >>>
+}
+
+func sorted_positions(ps []Pos) []Pos {
+	// <<<
+	ps = slices.Clone(ps)
+	slices.SortFunc(ps, func(a, b Pos) int {
+		return cmp.Or(cmp.Compare(a.Row, b.Row), cmp.Compare(a.Col, b.Col))
+	})
+	return ps
+	// >>>
+}
+
+func check_merge(t *testing.T, shapes []MergeShape, offset int, cells []Pos, ascended, removed []Pos, charges int) {
+	// <<<
+	t.Helper()
+	rules := CLASSIC
+	rules.MergeShapes = shapes
+	board := merge_test_board(cells...)
+
+	step := merge_step(rules, &board, offset)
+	if got, want := sorted_positions(step.Ascended), sorted_positions(ascended); !slices.Equal(got, want) {
+		t.Errorf("ascended %v, want %v", got, want)
+	}
+	if got, want := sorted_positions(step.Removed), sorted_positions(removed); !slices.Equal(got, want) {
+		t.Errorf("removed %v, want %v", got, want)
+	}
+	if step.Charges != charges {
+		t.Errorf("charges %v, want %v", step.Charges, charges)
+	}
+	for _, p := range ascended {
+		if board[p.Row][p.Col].Level != 2 || board[p.Row][p.Col].Health != rules.Health[1] {
+			t.Errorf("cell %v is %+v, want level 2 with full health", p, board[p.Row][p.Col])
+		}
+	}
+	for _, p := range removed {
+		if board[p.Row][p.Col].Type != EMPTY {
+			t.Errorf("cell %v is %+v, want empty", p, board[p.Row][p.Col])
+		}
+	}
+	// >>>
+}
+
+// every shape on its own, anchored at row 1, column 1
+func TestMergeShapes(t *testing.T) {
+	// <<<
+	anchor := Pos{1, 1}
+	for _, shapes := range [][]MergeShape{MERGE_LINES, MERGE_FOURS, MERGE_SQUARE, MERGE_CORNERS} {
+		for i, shape := range shapes {
+			cells, ascended, removed := []Pos{}, []Pos{}, []Pos{}
+			for k, d := range shape.Cells {
+				p := Pos{anchor.Row + d[1], anchor.Col + d[0]}
+				cells = append(cells, p)
+				if k == shape.Ascend {
+					ascended = append(ascended, p)
+				} else {
+					removed = append(removed, p)
+				}
+			}
+			t.Run(fmt.Sprintf("%v %v", shape.Name, i), func(t *testing.T) {
+				check_merge(t, []MergeShape{shape}, 0, cells, ascended, removed, 1+shape.Bonus)
+			})
+		}
+	}
+	// >>>
+}
+
+func TestMergeEdges(t *testing.T) {
+	// <<<
+	t.Run("top row, right edge", func(t *testing.T) {
+		check_merge(t, MERGE_LINES, 0,
+			[]Pos{{0, 9}, {0, 10}, {0, 11}},
+			[]Pos{{0, 10}}, []Pos{{0, 9}, {0, 11}}, 1)
+	})
+	t.Run("left column, border row", func(t *testing.T) {
+		check_merge(t, MERGE_LINES, 0,
+			[]Pos{{3, 0}, {4, 0}, {5, 0}},
+			[]Pos{{4, 0}}, []Pos{{3, 0}, {5, 0}}, 1)
+	})
+	t.Run("bottom half, right column, bottom row", func(t *testing.T) {
+		check_merge(t, MERGE_LINES, 1,
+			[]Pos{{9, 11}, {10, 11}, {11, 11}},
+			[]Pos{{10, 11}}, []Pos{{9, 11}, {11, 11}}, 1)
+	})
+	t.Run("bottom half, border row", func(t *testing.T) {
+		check_merge(t, MERGE_LINES, 1,
+			[]Pos{{6, 0}, {6, 1}, {6, 2}},
+			[]Pos{{6, 1}}, []Pos{{6, 0}, {6, 2}}, 1)
+	})
+	t.Run("lines across the border do not merge", func(t *testing.T) {
+		check_merge(t, MERGE_LINES, 0,
+			[]Pos{{4, 0}, {5, 0}, {6, 0}},
+			[]Pos{}, []Pos{}, 0)
+	})
+	// >>>
+}
+
+func TestMergePriority(t *testing.T) {
+	// <<<
+	t.Run("four in a row before lines", func(t *testing.T) {
+		check_merge(t, slices.Concat(MERGE_LINES, MERGE_FOURS), 0,
+			[]Pos{{2, 3}, {2, 4}, {2, 5}, {2, 6}},
+			[]Pos{{2, 4}}, []Pos{{2, 3}, {2, 5}, {2, 6}}, 1+2)
+	})
+	t.Run("square before corners", func(t *testing.T) {
+		check_merge(t, slices.Concat(MERGE_CORNERS, MERGE_SQUARE), 0,
+			[]Pos{{2, 3}, {2, 4}, {3, 3}, {3, 4}},
+			[]Pos{{2, 3}}, []Pos{{2, 4}, {3, 3}, {3, 4}}, 1+1)
+	})
+	t.Run("four in a row as lines ascends two", func(t *testing.T) {
+		check_merge(t, MERGE_LINES, 0,
+			[]Pos{{2, 3}, {2, 4}, {2, 5}, {2, 6}},
+			[]Pos{{2, 4}, {2, 5}}, []Pos{{2, 3}, {2, 6}}, 2)
+	})
+	// >>>
+}
+
+// a column of level 1 ascends into the middle of a row of level 2, which then
+// merges again in a second step
+func cascade_test_board() Board {
+	// <<<
+	board := merge_test_board(Pos{0, 1}, Pos{1, 1}, Pos{2, 1})
+	for _, p := range []Pos{{1, 0}, {1, 2}} {
+		board[p.Row][p.Col] = Cell{Type: ELEMENTAL, Element: AIR, Level: 2, Health: CLASSIC.Health[1]}
+	}
+	return board
+	// >>>
+}
+
+func TestCascade(t *testing.T) {
+	// <<<
+	for _, c := range []struct {
+		name    string
+		combo   []int
+		charges int
+		steps   []MergeStep
+	}{
+		{"combo", []int{1, 2, 3}, 1 + 2*2, []MergeStep{
+			{Ascended: []Pos{{1, 1}}, Removed: []Pos{{0, 1}, {2, 1}}, Combo: 1, Charges: 1},
+			{Ascended: []Pos{{1, 1}}, Removed: []Pos{{1, 0}, {1, 2}}, Combo: 2, Charges: 4},
+		}},
+		{"last combo repeats", []int{1}, 1 + 2, []MergeStep{
+			{Ascended: []Pos{{1, 1}}, Removed: []Pos{{0, 1}, {2, 1}}, Combo: 1, Charges: 1},
+			{Ascended: []Pos{{1, 1}}, Removed: []Pos{{1, 0}, {1, 2}}, Combo: 1, Charges: 2},
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			rules := CLASSIC
+			rules.Combo = c.combo
+			board := cascade_test_board()
+
+			charges, steps := cascade_board(rules, &board, 0)
+			if charges != c.charges {
+				t.Errorf("charges %v, want %v", charges, c.charges)
+			}
+			if len(steps) != len(c.steps) {
+				t.Fatalf("%v steps, want %v", len(steps), len(c.steps))
+			}
+			for i, step := range steps {
+				want := c.steps[i]
+				if !slices.Equal(sorted_positions(step.Ascended), want.Ascended) ||
+					!slices.Equal(sorted_positions(step.Removed), want.Removed) ||
+					step.Combo != want.Combo || step.Charges != want.Charges {
+					t.Errorf("step %v is %+v, want %+v", i, step, want)
+				}
+			}
+			if cell := board[1][1]; cell.Level != 3 || cell.Health != rules.Health[2] {
+				t.Errorf("cell {1 1} is %+v, want level 3 with full health", cell)
+			}
+		})
+	}
+	// >>>
+}
+
+func TestCascadeNothingToMerge(t *testing.T) {
+	// <<<
+	board := merge_test_board(Pos{0, 1}, Pos{1, 1})
+	charges, steps := cascade_board(CLASSIC, &board, 0)
+	if charges != 0 || len(steps) != 0 {
+		t.Errorf("charges %v and %v steps, want none", charges, len(steps))
+	}
+	// >>>
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// One-line position notation, similar to FEN:
+//
+//	<row 0>/<row 1>/.../<row 11> <charges 0>/<charges 1> <active player> <turn> <skip advance> [<loadout 0>/<loadout 1>]
+//
+// There is one row per row of the board, as many as the size of the ruleset
+// of the lobby. Rows are read left to right, a number is a run of empty cells, "x" is a
+// block and an elemental is its element letter followed by its level and its
+// health in base 36, e.g. "f13" is a level 1 fire elemental with 3 health,
+// then the letter of every active effect and its turns in base 36, e.g.
+// "f13b2" burns for 2 more turns.
+// Charges are comma separated in the order of the loadout, loadouts are
+// comma separated spells and left out when both are DEFAULT_LOADOUT.
+//
+//	12/12/3f11f117/12/12/12/12/12/12/5w116/12/x10x 4,5,7,9,10/4,5,7,9,10 0 1 0
+//	12/12/3f11f11s37/12/12/12/12/12/12/5w11t16/12/x10x 4,5,7,9,10/4,6,5,6,8 0 1 0 fs,hv,af,dt,ms/fs,sh,sw,fz,su
+
+var ELEMENT_LETTERS = map[Element]byte{
+	// <<<
+	AIR:    'a',
+	ROCK:   'r',
+	FIRE:   'f',
+	WATER:  'w',
+	NATURE: 'n',
+	ENERGY: 'e',
+	// >>>
+}
+
+func validate_game(rules Ruleset, game Game) error {
+	// <<<
+	if game.ActivePlayer != 0 && game.ActivePlayer != 1 {
+		return fmt.Errorf("active player must be 0 or 1")
+	}
+	if game.Turn < 1 {
+		return fmt.Errorf("turn must be at least 1")
+	}
+
+	for p := 0; p < 2; p++ {
+		if err := validate_loadout(game.Loadouts[p]); err != nil {
+			return fmt.Errorf("loadout of player %v: %v", p, err)
+		}
+		charges := loadout_charges(rules, game.Loadouts[p])
+		for i := range charges {
+			if game.Players[p][i] < 0 || game.Players[p][i] > charges[i] {
+				return fmt.Errorf("charges of %v for player %v must be within 0..%v", game.Loadouts[p][i], p, charges[i])
+			}
+		}
+	}
+
+	if len(game.Board) != rules.Size {
+		return fmt.Errorf("the board must have %v rows", rules.Size)
+	}
+	for i := 0; i < rules.Size; i++ {
+		if len(game.Board[i]) != rules.Size {
+			return fmt.Errorf("row %v must have %v cells", i, rules.Size)
+		}
+		for j := 0; j < rules.Size; j++ {
+			cell := game.Board[i][j]
+			switch cell.Type {
+			case EMPTY, BLOCK:
+				if cell.Element != "" || cell.Level != 0 || cell.Health != 0 || cell.Status != [EFFECT_COUNT]int{} {
+					return fmt.Errorf("cell %v,%v: %v cells have no element, level, health or effects", i, j, cell.Type)
+				}
+			case ELEMENTAL:
+				if !slices.Contains(ELEMENTS, cell.Element) {
+					return fmt.Errorf("cell %v,%v: invalid element %q", i, j, cell.Element)
+				}
+				if cell.Level < 1 || cell.Level > max_level(rules) {
+					return fmt.Errorf("cell %v,%v: invalid level %v", i, j, cell.Level)
+				}
+				if cell.Health < 1 || cell.Health > rules.Health[cell.Level-1] {
+					return fmt.Errorf("cell %v,%v: health must be within 1..%v", i, j, rules.Health[cell.Level-1])
+				}
+				for e, turns := range cell.Status {
+					if turns < 0 || turns > 35 {
+						return fmt.Errorf("cell %v,%v: turns of %v must be within 0..35", i, j, EFFECT_NAMES[e])
+					}
+				}
+			default:
+				return fmt.Errorf("cell %v,%v: invalid type %q", i, j, cell.Type)
+			}
+		}
+	}
+
+	return nil
+	// >>>
+}
+
+func format_position(game Game, skip_advance int) string {
+	// <<<
+	var sb strings.Builder
+
+	for i := range game.Board {
+		if i > 0 {
+			sb.WriteByte('/')
+		}
+		empty := 0
+		for j := range game.Board[i] {
+			cell := game.Board[i][j]
+			if cell.Type == EMPTY {
+				empty += 1
+				continue
+			}
+			if empty > 0 {
+				sb.WriteString(strconv.Itoa(empty))
+				empty = 0
+			}
+			if cell.Type == BLOCK {
+				sb.WriteByte('x')
+				continue
+			}
+			sb.WriteByte(ELEMENT_LETTERS[cell.Element])
+			sb.WriteString(strconv.Itoa(cell.Level))
+			sb.WriteString(strconv.FormatInt(int64(cell.Health), 36))
+			for e, turns := range cell.Status {
+				if turns > 0 {
+					sb.WriteByte(EFFECT_LETTERS[e])
+					sb.WriteString(strconv.FormatInt(int64(turns), 36))
+				}
+			}
+		}
+		if empty > 0 {
+			sb.WriteString(strconv.Itoa(empty))
+		}
+	}
+
+	sb.WriteByte(' ')
+	for p := 0; p < 2; p++ {
+		if p > 0 {
+			sb.WriteByte('/')
+		}
+		for i, c := range game.Players[p] {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(strconv.Itoa(c))
+		}
+	}
+
+	fmt.Fprintf(&sb, " %v %v %v", game.ActivePlayer, game.Turn, skip_advance)
+	if game.Loadouts != [2][LOADOUT_SIZE]SpellID{DEFAULT_LOADOUT, DEFAULT_LOADOUT} {
+		for p := 0; p < 2; p++ {
+			sb.WriteByte(" /"[p])
+			for i, id := range game.Loadouts[p] {
+				if i > 0 {
+					sb.WriteByte(',')
+				}
+				sb.WriteString(string(id))
+			}
+		}
+	}
+	return sb.String()
+	// >>>
+}
+
+func parse_row(game *Game, row int, s string) error {
+	// <<<
+	col, size := 0, len(game.Board[row])
+	for i := 0; i < len(s); {
+		if col >= size {
+			return fmt.Errorf("row %v: more than %v cells", row, size)
+		}
+
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			n := 0
+			for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+				n = n*10 + int(s[i]-'0')
+				i += 1
+			}
+			if n == 0 || col+n > size {
+				return fmt.Errorf("row %v: invalid run of empty cells", row)
+			}
+			for ; n > 0; n-- {
+				game.Board[row][col].Type = EMPTY
+				col += 1
+			}
+		case c == 'x':
+			game.Board[row][col].Type = BLOCK
+			col += 1
+			i += 1
+		default:
+			if i+3 > len(s) {
+				return fmt.Errorf("row %v: truncated elemental %q", row, s[i:])
+			}
+			element := Element("")
+			for e, letter := range ELEMENT_LETTERS {
+				if letter == c {
+					element = e
+				}
+			}
+			if element == "" {
+				return fmt.Errorf("row %v: invalid element %q", row, c)
+			}
+			level, err1 := strconv.ParseInt(s[i+1:i+2], 10, 64)
+			health, err2 := strconv.ParseInt(s[i+2:i+3], 36, 64)
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf("row %v: invalid elemental %q", row, s[i:i+3])
+			}
+			game.Board[row][col].Type = ELEMENTAL
+			game.Board[row][col].Element = element
+			game.Board[row][col].Level = int(level)
+			game.Board[row][col].Health = int(health)
+			i += 3
+			for i+1 < len(s) && slices.Contains(EFFECT_LETTERS[:], s[i]) {
+				turns, err := strconv.ParseInt(s[i+1:i+2], 36, 64)
+				if err != nil {
+					return fmt.Errorf("row %v: invalid effect %q", row, s[i:i+2])
+				}
+				game.Board[row][col].Status[slices.Index(EFFECT_LETTERS[:], s[i])] = int(turns)
+				i += 2
+			}
+			col += 1
+		}
+	}
+	if col != size {
+		return fmt.Errorf("row %v: expected %v cells, got %v", row, size, col)
+	}
+	return nil
+	// >>>
+}
+
+func parse_position(rules Ruleset, s string) (game Game, skip_advance int, err error) {
+	// <<<
+	fields := strings.Fields(s)
+	if len(fields) != 5 && len(fields) != 6 {
+		return game, 0, fmt.Errorf("expected 5 or 6 fields, got %v", len(fields))
+	}
+
+	rows := strings.Split(fields[0], "/")
+	if len(rows) != rules.Size {
+		return game, 0, fmt.Errorf("expected %v rows, got %v", rules.Size, len(rows))
+	}
+	game.Board = make_board(rules.Size)
+	for i, row := range rows {
+		if err = parse_row(&game, i, row); err != nil {
+			return game, 0, err
+		}
+	}
+
+	charges := strings.Split(fields[1], "/")
+	if len(charges) != 2 {
+		return game, 0, fmt.Errorf("expected charges for 2 players")
+	}
+	for p := 0; p < 2; p++ {
+		values := strings.Split(charges[p], ",")
+		if len(values) != LOADOUT_SIZE {
+			return game, 0, fmt.Errorf("expected %v charges for player %v", LOADOUT_SIZE, p)
+		}
+		for i, v := range values {
+			if game.Players[p][i], err = strconv.Atoi(v); err != nil {
+				return game, 0, fmt.Errorf("invalid charge %q", v)
+			}
+		}
+	}
+
+	if game.ActivePlayer, err = strconv.Atoi(fields[2]); err != nil {
+		return game, 0, fmt.Errorf("invalid active player %q", fields[2])
+	}
+	if game.Turn, err = strconv.Atoi(fields[3]); err != nil {
+		return game, 0, fmt.Errorf("invalid turn %q", fields[3])
+	}
+	if skip_advance, err = strconv.Atoi(fields[4]); err != nil || skip_advance < 0 || skip_advance > 1 {
+		return game, 0, fmt.Errorf("invalid skip advance %q", fields[4])
+	}
+
+	game.Loadouts = [2][LOADOUT_SIZE]SpellID{DEFAULT_LOADOUT, DEFAULT_LOADOUT}
+	if len(fields) == 6 {
+		loadouts := strings.Split(fields[5], "/")
+		if len(loadouts) != 2 {
+			return game, 0, fmt.Errorf("expected loadouts for 2 players")
+		}
+		for p := 0; p < 2; p++ {
+			values := strings.Split(loadouts[p], ",")
+			if len(values) != LOADOUT_SIZE {
+				return game, 0, fmt.Errorf("expected %v spells for player %v", LOADOUT_SIZE, p)
+			}
+			for i, v := range values {
+				game.Loadouts[p][i] = SpellID(v)
+			}
+		}
+	}
+
+	return game, skip_advance, validate_game(rules, game)
+	// >>>
+}
+
+// =============================================================================
+
+func handle_load(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		PlayerID string       `json:"player_id"`
+		Position string       `json:"position"`
+		Options  LobbyOptions `json:"options"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate_options(data.Options); err != nil {
+		http.Error(w, "Invalid Options: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if data.Options.Draft || data.Options.Placement || data.Options.Layout != "" || data.Options.Fair {
+		http.Error(w, "Invalid Options: drafts, placements and layouts need a new board", http.StatusBadRequest)
+		return
+	}
+	game, skip_advance, err := parse_position(options_ruleset(data.Options), data.Position)
+	if err != nil {
+		http.Error(w, "Invalid Position: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	gw := make_lobby(game, data.PlayerID, data.Options)
+	gw.SkipAdvance = skip_advance
+	lobby_id := add_lobby(gw)
+
+	response := struct {
+		LobbyID        string  `json:"lobby_id"`
+		GameSOA        GameSOA `json:"game_soa"`
+		PlayerIndex    int     `json:"player_index"`
+		ReconnectToken string  `json:"reconnect_token"`
+	}{
+		LobbyID:        lobby_id,
+		GameSOA:        aos2soa(gw.Game),
+		PlayerIndex:    seat_of(gw, data.PlayerID),
+		ReconnectToken: reconnect_token(gw, data.PlayerID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	// >>>
+}
+
+func handle_position(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		LobbyID string `json:"lobby_id"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	games.RLock()
+	gw, ok := games.m[data.LobbyID]
+	games.RUnlock()
+
+	if !ok {
+		http.Error(w, "Invalid Lobby ID", http.StatusBadRequest)
+		return
+	}
+
+	response := struct {
+		Ok       bool   `json:"ok"`
+		Position string `json:"position"`
+	}{
+		Ok:       ok,
+		Position: format_position(gw.Game, gw.SkipAdvance),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	// >>>
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Lobbies with the placement option start on an empty board. Once both
+// players are seated, and the draft is over if there is one, every player
+// places a budget of level 1 elementals of their elements on their own half
+// within PLACEMENT_TIME. No placement may complete a merge configuration.
+// The phase ends when both players are ready or the time is up, and the
+// elementals left in a budget are then placed at random.
+
+const PLACEMENT_TIME = 2 * time.Minute
+
+type Placement struct {
+	// <<<
+	Elements [2][]Element `json:"elements"` // by seat
+	Budget   [2]int       `json:"budget"`   // elementals left to place
+	Ready    [2]bool      `json:"ready"`
+	Deadline time.Time    `json:"deadline"` // zero until both players are seated
+	Done     bool         `json:"-"`
+	// >>>
+}
+
+type PlaceKind string
+
+const (
+	PLACE  PlaceKind = "place"
+	REMOVE PlaceKind = "remove"
+	READY  PlaceKind = "ready"
+)
+
+func placing(gw GameWrapper) bool {
+	return gw.Options.Placement && !gw.Placement.Done
+}
+
+func start_placement(gw *GameWrapper, sides [2][]Element) {
+	// <<<
+	budget := random_elemental_count(gw.Rules, rand.New(rand.NewSource(gw.Seed)))
+	gw.Placement = Placement{
+		Elements: sides,
+		Budget:   [2]int{budget, budget},
+	}
+	// >>>
+}
+
+// the board may not hold a merge configuration in the half of seat
+func would_merge(rules Ruleset, board Board, seat int) bool {
+	board = copy_board(board)
+	return merge_board(rules, &board, 1-seat) > 0
+}
+
+// starts the clock once both players are seated and ends the placement when
+// it runs out, games must be locked
+func check_placement(gw *GameWrapper) {
+	// <<<
+	if !placing(*gw) || drafting(*gw) || !seated(*gw) {
+		return
+	}
+	if gw.Placement.Deadline.IsZero() {
+		gw.Placement.Deadline = time.Now().Add(PLACEMENT_TIME)
+	}
+	if time.Now().After(gw.Placement.Deadline) {
+		finish_placement(gw)
+	}
+	// >>>
+}
+
+func finish_placement(gw *GameWrapper) {
+	// <<<
+	rng := rand.New(rand.NewSource(gw.Seed))
+	gw.Game.Board = copy_board(gw.Game.Board)
+	for seat := 0; seat < 2; seat++ {
+		cells := []Pos{}
+		for row := range gw.Game.Board {
+			for col := range gw.Game.Board[row] {
+				if owner(gw.Game.Board, row) == seat && gw.Game.Board[row][col].Type == EMPTY {
+					cells = append(cells, Pos{row, col})
+				}
+			}
+		}
+		rng.Shuffle(len(cells), func(i, j int) {
+			cells[i], cells[j] = cells[j], cells[i]
+		})
+
+		elements := gw.Placement.Elements[seat]
+		for _, p := range cells {
+			if gw.Placement.Budget[seat] == 0 {
+				break
+			}
+			place_elemental(gw.Rules, gw.Game.Board, p, elements[rng.Intn(len(elements))])
+			if would_merge(gw.Rules, gw.Game.Board, seat) {
+				clear_cell(gw.Game.Board, p)
+				continue
+			}
+			gw.Placement.Budget[seat] -= 1
+		}
+	}
+
+	gw.Placement.Done = true
+	gw.Start = format_position(gw.Game, gw.SkipAdvance)
+	// >>>
+}
+
+func place_elemental(rules Ruleset, board Board, p Pos, element Element) {
+	// <<<
+	board[p.Row][p.Col].Type = ELEMENTAL
+	board[p.Row][p.Col].Element = element
+	board[p.Row][p.Col].Level = 1
+	board[p.Row][p.Col].Health = rules.Health[0]
+	// >>>
+}
+
+func clear_cell(board Board, p Pos) {
+	// <<<
+	board[p.Row][p.Col].Type = EMPTY
+	board[p.Row][p.Col].Element = ""
+	board[p.Row][p.Col].Level = 0
+	board[p.Row][p.Col].Health = 0
+	// >>>
+}
+
+func apply_placement(gw *GameWrapper, seat int, kind PlaceKind, p Pos, element Element) error {
+	// <<<
+	placement := &gw.Placement
+	if !placing(*gw) || drafting(*gw) || !seated(*gw) {
+		return fmt.Errorf("There is no placement going on")
+	}
+	if seat != 0 && seat != 1 {
+		return fmt.Errorf("Only the players can place elementals")
+	}
+	if placement.Ready[seat] {
+		return fmt.Errorf("You are already ready")
+	}
+	if kind == READY {
+		placement.Ready[seat] = true
+		if placement.Ready[1-seat] {
+			finish_placement(gw)
+		}
+		return nil
+	}
+
+	if !valid(gw.Game.Board, p.Row, p.Col) {
+		return fmt.Errorf("Invalid Cell")
+	}
+	if owner(gw.Game.Board, p.Row) != seat {
+		return fmt.Errorf("Can place only within one's own borders.")
+	}
+	cell := gw.Game.Board[p.Row][p.Col]
+
+	switch kind {
+	case PLACE:
+		if cell.Type != EMPTY {
+			return fmt.Errorf("The cell is not empty")
+		}
+		if !slices.Contains(placement.Elements[seat], element) {
+			return fmt.Errorf("Invalid Element")
+		}
+		if placement.Budget[seat] == 0 {
+			return fmt.Errorf("No elementals left to place")
+		}
+		board := copy_board(gw.Game.Board)
+		place_elemental(gw.Rules, board, p, element)
+		if would_merge(gw.Rules, board, seat) {
+			return fmt.Errorf("The placement would merge")
+		}
+		gw.Game.Board = board
+		placement.Budget[seat] -= 1
+	case REMOVE:
+		if cell.Type != ELEMENTAL {
+			return fmt.Errorf("There is no elemental to remove")
+		}
+		gw.Game.Board = copy_board(gw.Game.Board)
+		clear_cell(gw.Game.Board, p)
+		placement.Budget[seat] += 1
+	default:
+		return fmt.Errorf("Invalid Action")
+	}
+	return nil
+	// >>>
+}
+
+func placement_state(gw GameWrapper) *Placement {
+	// <<<
+	if !placing(gw) || drafting(gw) {
+		return nil
+	}
+	return &gw.Placement
+	// >>>
+}
+
+// in hotseat lobbies the half of the cell tells the seat, and getting ready
+// ends the placement of one half at a time
+func place(lobby_id, player_id string, kind PlaceKind, p Pos, element Element) (gw GameWrapper, err error) {
+	// <<<
+	games.Lock()
+	defer games.Unlock()
+
+	gw, found := games.m[lobby_id]
+	if !found {
+		return gw, fmt.Errorf("Invalid Lobby ID")
+	}
+
+	touch(&gw, player_id)
+	update_lobby(&gw)
+	player_index := seat_of(gw, player_id)
+	if gw.Options.Hotseat && gw.Owner == player_id {
+		player_index = slices.Index(gw.Placement.Ready[:], false)
+		if kind != READY && valid(gw.Game.Board, p.Row, p.Col) {
+			player_index = owner(gw.Game.Board, p.Row)
+		}
+	}
+	err = apply_placement(&gw, player_index, kind, p, element)
+	games.m[lobby_id] = gw
+	return gw, err
+	// >>>
+}
+
+// =============================================================================
+
+func handle_place(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		LobbyID  string    `json:"lobby_id"`
+		PlayerID string    `json:"player_id"`
+		Kind     PlaceKind `json:"kind"`
+		Pos      Pos       `json:"pos"`
+		Element  Element   `json:"element"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	gw, err := place(data.LobbyID, data.PlayerID, data.Kind, data.Pos, data.Element)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := struct {
+		Ok        bool       `json:"ok"`
+		Placement *Placement `json:"placement"` // null once the placement is over
+		GameSOA   GameSOA    `json:"game_soa"`
+	}{
+		Ok:        true,
+		Placement: placement_state(gw),
+		GameSOA:   aos2soa(gw.Game),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	// >>>
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Puzzles are authored positions with a goal for the player to move. A
+// submitted solution is a list of actions in move notation which is played
+// with apply_action, all of them by the player to move, and solved when the
+// goal holds after at most MaxActions of them.
+
+type Goal string
+
+const (
+	DESTROY_ALL Goal = "destroy_all" // no enemy elemental is left
+	DESTROY     Goal = "destroy"     // the elemental on Target is gone
+)
+
+type Puzzle struct {
+	// <<<
+	ID         string   `json:"id"`
+	Title      string   `json:"title"`
+	Position   string   `json:"position"`
+	Goal       Goal     `json:"goal"`
+	Target     Pos      `json:"target"`
+	MaxActions int      `json:"max_actions"`
+	Attempts   int      `json:"attempts"`
+	Solves     int      `json:"solves"`
+	Solution   []string `json:"-"` // of authored puzzles, checked in init()
+	// >>>
+}
+
+var puzzles = struct {
+	sync.RWMutex
+	m     map[string]Puzzle
+	order []string
+}{
+	m: make(map[string]Puzzle),
+}
+
+var AUTHORED_PUZZLES = []Puzzle{
+	// <<<
+	{
+		ID:         "fireball",
+		Title:      "Destroy all enemy elementals in 2 actions",
+		Position:   "12/12/12/12/5w116/8w113/12/8f113/12/12/12/12 4,5,7,9,10/4,5,7,9,10 0 1 0",
+		Goal:       DESTROY_ALL,
+		MaxActions: 2,
+		Solution:   []string{"S fs f8", "A i5xi7"},
+	},
+	{
+		ID:         "double",
+		Title:      "Destroy all enemy elementals in 3 actions",
+		Position:   "12/12/12/12/12/2w116w112/2f116f112/12/12/12/12/12 4,5,7,9,10/4,5,7,9,10 0 1 0",
+		Goal:       DESTROY_ALL,
+		MaxActions: 3,
+		Solution:   []string{"S dt", "A c6xc7", "A j6xj7"},
+	},
+	{
+		ID:         "figurine",
+		Title:      "Destroy all enemy elementals in 1 action",
+		Position:   "12/12/4w117/7w114/12/4w117/12/12/12/f1111/12/12 4,5,7,9,10/4,5,7,9,10 0 1 0",
+		Goal:       DESTROY_ALL,
+		MaxActions: 1,
+		Solution:   []string{"S af e9"},
+	},
+	// >>>
+}
+
+func init() {
+	// <<<
+	for _, p := range AUTHORED_PUZZLES {
+		if err := validate_puzzle(p); err != nil {
+			panic(fmt.Sprintf("puzzle %v: %v", p.ID, err))
+		}
+		if _, solved, reason := check_solution(p, p.Solution); !solved {
+			panic(fmt.Sprintf("puzzle %v: the solution fails, %v", p.ID, reason))
+		}
+		puzzles.m[p.ID] = p
+		puzzles.order = append(puzzles.order, p.ID)
+	}
+	// >>>
+}
+
+func validate_puzzle(p Puzzle) error {
+	// <<<
+	game, _, err := parse_position(CLASSIC, p.Position)
+	if err != nil {
+		return fmt.Errorf("invalid position: %w", err)
+	}
+	if p.MaxActions < 1 || p.MaxActions > 20 {
+		return fmt.Errorf("max actions must be within 1..20")
+	}
+	switch p.Goal {
+	case DESTROY_ALL:
+	case DESTROY:
+		t := p.Target
+		if !valid(game.Board, t.Row, t.Col) || game.Board[t.Row][t.Col].Type != ELEMENTAL || owner(game.Board, t.Row) == game.ActivePlayer {
+			return fmt.Errorf("target must be an enemy elemental")
+		}
+	default:
+		return fmt.Errorf("invalid goal %q", p.Goal)
+	}
+	if over, _ := game_winner(game); over {
+		return fmt.Errorf("the game is already over")
+	}
+	return nil
+	// >>>
+}
+
+func goal_met(p Puzzle, game Game, solver int) bool {
+	// <<<
+	switch p.Goal {
+	case DESTROY_ALL:
+		return len(enemy_elementals(game.Board, solver)) == 0
+	case DESTROY:
+		return game.Board[p.Target.Row][p.Target.Col].Type != ELEMENTAL
+	}
+	return false
+	// >>>
+}
+
+// reason explains a failed attempt
+func check_solution(p Puzzle, moves []string) (gw GameWrapper, solved bool, reason string) {
+	// <<<
+	game, skip_advance, err := parse_position(CLASSIC, p.Position)
+	if err != nil {
+		return gw, false, err.Error()
+	}
+	gw = GameWrapper{
+		Game:              game,
+		Rules:             CLASSIC,
+		Players:           []string{"0", "1"},
+		PlayerCanUseSpell: []bool{true, true},
+		SkipAdvance:       skip_advance,
+	}
+	solver := game.ActivePlayer
+
+	if len(moves) > p.MaxActions {
+		return gw, false, fmt.Sprintf("at most %v actions are allowed", p.MaxActions)
+	}
+	for i, move := range moves {
+		action, err := parse_move(len(game.Board), move)
+		if err != nil {
+			return gw, false, fmt.Sprintf("action %v: %v", i+1, err)
+		}
+		if gw.Game.ActivePlayer != solver {
+			return gw, false, fmt.Sprintf("action %v: the turn has passed to the opponent", i+1)
+		}
+		if err := apply_action(&gw, solver, action); err != nil {
+			return gw, false, fmt.Sprintf("action %v: %v", i+1, err)
+		}
+		if goal_met(p, gw.Game, solver) {
+			return gw, true, ""
+		}
+	}
+	return gw, false, "the goal was not reached"
+	// >>>
+}
+
+// =============================================================================
+
+func handle_puzzles(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	puzzles.RLock()
+	list := make([]Puzzle, 0, len(puzzles.order))
+	for _, id := range puzzles.order {
+		list = append(list, puzzles.m[id])
+	}
+	puzzles.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+	// >>>
+}
+
+func handle_new_puzzle(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data Puzzle
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+	if err := validate_puzzle(data); err != nil {
+		http.Error(w, "Invalid Puzzle: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data.Attempts, data.Solves = 0, 0
+	puzzles.Lock()
+	data.ID = make_id(8)
+	for _, taken := puzzles.m[data.ID]; taken; _, taken = puzzles.m[data.ID] {
+		data.ID = make_id(8)
+	}
+	puzzles.m[data.ID] = data
+	puzzles.order = append(puzzles.order, data.ID)
+	puzzles.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+	// >>>
+}
+
+func handle_solve_puzzle(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		PuzzleID string   `json:"puzzle_id"`
+		Moves    []string `json:"moves"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	puzzles.RLock()
+	p, ok := puzzles.m[data.PuzzleID]
+	puzzles.RUnlock()
+
+	if !ok {
+		http.Error(w, "Invalid Puzzle ID", http.StatusBadRequest)
+		return
+	}
+
+	gw, solved, reason := check_solution(p, data.Moves)
+
+	puzzles.Lock()
+	p = puzzles.m[data.PuzzleID]
+	p.Attempts += 1
+	if solved {
+		p.Solves += 1
+	}
+	puzzles.m[data.PuzzleID] = p
+	puzzles.Unlock()
+
+	response := struct {
+		Ok        bool    `json:"ok"`
+		Reason    string  `json:"reason"`
+		SolveRate float64 `json:"solve_rate"`
+		GameSOA   GameSOA `json:"game_soa"`
+	}{
+		Ok:        solved,
+		Reason:    reason,
+		SolveRate: float64(p.Solves) / float64(p.Attempts),
+		GameSOA:   aos2soa(gw.Game),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	// >>>
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+// moving the target away is no way to destroy it
+func TestPuzzleMoveEnemyTarget(t *testing.T) {
+	// <<<
+	p := AUTHORED_PUZZLES[0]
+	p.Goal, p.Target = DESTROY, Pos{4, 5}
+	if err := validate_puzzle(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, solved, _ := check_solution(p, []string{"M f8-g8"}); solved {
+		t.Errorf("solved by moving an enemy elemental")
+	}
+	// >>>
+}
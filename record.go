@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Move notation. Columns are letters from the left, ranks are counted from
+// player 0's side of the board, so "a1" is the lower left corner (row
+// size-1, col 0) in server coordinates.
+//
+//	M c3-e3    move
+//	A d5xd8    attack
+//	S ms f2    spell with a target
+//	S sw b1-c2 spell with two targets
+//	S dt       spell without a target
+//	--         skip
+
+func format_square(size int, p Pos) string {
+	return fmt.Sprintf("%c%d", 'a'+p.Col, size-p.Row)
+}
+
+func parse_square(size int, s string) (Pos, error) {
+	// <<<
+	if len(s) < 2 || s[0] < 'a' || int(s[0]) >= 'a'+size {
+		return Pos{}, fmt.Errorf("invalid square %q", s)
+	}
+	rank, err := strconv.Atoi(s[1:])
+	if err != nil || rank < 1 || rank > size {
+		return Pos{}, fmt.Errorf("invalid square %q", s)
+	}
+	return Pos{Row: size - rank, Col: int(s[0] - 'a')}, nil
+	// >>>
+}
+
+func format_move(size int, action Action) string {
+	// <<<
+	switch action.Type {
+	case MOVE:
+		return "M " + format_square(size, action.From) + "-" + format_square(size, action.To)
+	case ATTACK:
+		return "A " + format_square(size, action.From) + "x" + format_square(size, action.To)
+	case SPELL:
+		inside := func(p Pos) bool { return p.Row >= 0 && p.Row < size && p.Col >= 0 && p.Col < size }
+		spell, ok := SPELL_REGISTRY[action.Spell]
+		switch {
+		case !inside(action.To):
+			return "S " + string(action.Spell)
+		case ok && spell.info().Target == TARGET_OWN_PAIR:
+			return "S " + string(action.Spell) + " " + format_square(size, action.From) + "-" + format_square(size, action.To)
+		}
+		return "S " + string(action.Spell) + " " + format_square(size, action.To)
+	default:
+		return "--"
+	}
+	// >>>
+}
+
+func parse_move(size int, s string) (Action, error) {
+	// <<<
+	fields := strings.Fields(strings.ToLower(s))
+	none := Pos{-1, -1}
+
+	switch {
+	case len(fields) == 1 && fields[0] == "--":
+		return Action{Type: SKIP, From: none, To: none}, nil
+	case len(fields) == 2 && (fields[0] == "m" || fields[0] == "a"):
+		separator, action_type := "-", MOVE
+		if fields[0] == "a" {
+			separator, action_type = "x", ATTACK
+		}
+		squares := strings.Split(fields[1], separator)
+		if len(squares) != 2 {
+			return Action{}, fmt.Errorf("invalid move %q", s)
+		}
+		from, err := parse_square(size, squares[0])
+		if err != nil {
+			return Action{}, err
+		}
+		to, err := parse_square(size, squares[1])
+		if err != nil {
+			return Action{}, err
+		}
+		return Action{Type: action_type, From: from, To: to}, nil
+	case (len(fields) == 2 || len(fields) == 3) && fields[0] == "s":
+		action := Action{Type: SPELL, Spell: SpellID(fields[1]), From: none, To: none}
+		if !slices.Contains(SPELLS, action.Spell) {
+			return Action{}, fmt.Errorf("invalid spell %q", fields[1])
+		}
+		if len(fields) == 3 {
+			squares := strings.Split(fields[2], "-")
+			if len(squares) > 2 {
+				return Action{}, fmt.Errorf("invalid move %q", s)
+			}
+			if len(squares) == 2 {
+				from, err := parse_square(size, squares[0])
+				if err != nil {
+					return Action{}, err
+				}
+				action.From = from
+			}
+			to, err := parse_square(size, squares[len(squares)-1])
+			if err != nil {
+				return Action{}, err
+			}
+			action.To = to
+		}
+		return action, nil
+	}
+
+	return Action{}, fmt.Errorf("invalid move %q", s)
+	// >>>
+}
+
+// =============================================================================
+
+// plays the history of a lobby from its start position, calling step with
+// the state before every action
+func replay(gw GameWrapper, step func(before GameWrapper, action Action)) (GameWrapper, error) {
+	// <<<
+	game, skip_advance, err := parse_position(gw.Rules, gw.Start)
+	if err != nil {
+		return gw, err
+	}
+	r := GameWrapper{
+		Game:              game,
+		Rules:             gw.Rules,
+		Players:           []string{"0", "1"},
+		PlayerCanUseSpell: []bool{true, true},
+		SkipAdvance:       skip_advance,
+	}
+
+	for i, action := range gw.History {
+		if step != nil {
+			step(r, action)
+		}
+		if err := apply_action(&r, r.Game.ActivePlayer, action); err != nil {
+			return r, fmt.Errorf("action %v (%v): %w", i+1, format_move(len(r.Game.Board), action), err)
+		}
+	}
+	return r, nil
+	// >>>
+}
+
+func result_text(gw GameWrapper) string {
+	// <<<
+	over, winner := game_result(gw)
+	switch {
+	case !over:
+		return "*"
+	case winner == 0:
+		return "1-0"
+	case winner == 1:
+		return "0-1"
+	}
+	return "1/2-1/2"
+	// >>>
+}
+
+// PGN-like record of a whole game, the Position header holds the start
+// position in the notation of format_position
+func export_game(lobby_id string, gw GameWrapper) (string, error) {
+	// <<<
+	var sb strings.Builder
+
+	player := func(i int) string { // never the player id, it is all /api/action asks for
+		if i < len(gw.Players) && gw.Players[i] != "" {
+			return fmt.Sprintf("Seat %v", i)
+		}
+		return "?"
+	}
+	header := func(key, value string) {
+		fmt.Fprintf(&sb, "[%v %q]\n", key, value)
+	}
+	header("Event", "Elementals")
+	header("Lobby", lobby_id)
+	header("Date", gw.CreatedAt.Format("2006.01.02"))
+	header("Player0", player(0))
+	header("Player1", player(1))
+	if gw.Seed != 0 {
+		header("Seed", strconv.FormatInt(gw.Seed, 10))
+	}
+	header("Position", gw.Start)
+	header("First", strconv.Itoa(gw.First))
+	header("Rules", gw.Rules.Name)
+	if start, _, err := parse_position(gw.Rules, gw.Start); err == nil {
+		f := score_fairness(gw.Rules, start.Board)
+		header("Fairness", fmt.Sprintf("near merges %v-%v, attack lanes %v-%v",
+			f.NearMerges[0], f.NearMerges[1], f.AttackLanes[0], f.AttackLanes[1]))
+	}
+	if gw.Series.BestOf > 1 {
+		header("Series", fmt.Sprintf("game %v of best of %v", gw.Series.Number, gw.Series.BestOf))
+	}
+	header("Result", result_text(gw))
+	sb.WriteByte('\n')
+
+	line := 0
+	turn := -1
+	write := func(s string) {
+		if line > 0 && line+len(s) >= 80 {
+			sb.WriteByte('\n')
+			line = 0
+		} else if line > 0 {
+			sb.WriteByte(' ')
+			line += 1
+		}
+		sb.WriteString(s)
+		line += len(s)
+	}
+	_, err := replay(gw, func(before GameWrapper, action Action) {
+		if before.Game.Turn != turn {
+			turn = before.Game.Turn
+			write(fmt.Sprintf("%v.", turn))
+		}
+		write(format_move(len(before.Game.Board), action))
+	})
+	if err != nil {
+		return "", err
+	}
+	write(result_text(gw))
+	sb.WriteByte('\n')
+
+	return sb.String(), nil
+	// >>>
+}
+
+func handle_export(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	lobby_id := strings.ToUpper(r.URL.Query().Get("lobby_id"))
+	games.RLock()
+	gw, ok := games.m[lobby_id]
+	games.RUnlock()
+
+	if !ok {
+		http.Error(w, "Invalid Lobby ID", http.StatusBadRequest)
+		return
+	}
+
+	record, err := export_game(lobby_id, gw)
+	if err != nil {
+		http.Error(w, "Error replaying game: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q",
+		"elementals-"+lobby_id+"-"+gw.CreatedAt.Format(time.DateOnly)+".txt"))
+	w.Write([]byte(record))
+	// >>>
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Server side rendering of boards for chat posts and link previews. The
+// board is drawn from player 0's side with the colors of client.js, every
+// elemental as a disc in its two COLORS whose size grows with the level and
+// a health bar below it.
+//
+//	/api/board.png?lobby_id=ABCDEF&cell=48
+//	/api/history.gif?lobby_id=ABCDEF&cell=32&delay=60
+
+const (
+	RENDER_CELL_DEFAULT = 48
+	RENDER_CELL_MIN     = 8
+	RENDER_CELL_MAX     = 96
+)
+
+var COLORS = map[Element][2]color.RGBA{
+	// <<< same as COLORS in client.js
+	AIR:    {{0x06, 0xb6, 0xd4, 0xff}, {0x7d, 0xd3, 0xfc, 0xff}}, // cyan-500      sky-300
+	ROCK:   {{0x71, 0x71, 0x7a, 0xff}, {0x3f, 0x3f, 0x46, 0xff}}, // zinc-500      zinc-700
+	FIRE:   {{0xfb, 0x92, 0x3c, 0xff}, {0xdc, 0x26, 0x26, 0xff}}, // orange-400    red-600
+	WATER:  {{0x0e, 0xa5, 0xe9, 0xff}, {0x25, 0x63, 0xeb, 0xff}}, // sky-500       blue-600
+	NATURE: {{0x22, 0xc5, 0x5e, 0xff}, {0x0d, 0x94, 0x88, 0xff}}, // green-500     teal-600
+	ENERGY: {{0xd9, 0x46, 0xef, 0xff}, {0x6d, 0x28, 0xd9, 0xff}}, // fuchsia-500   violet-700
+	// >>>
+}
+
+var (
+	COLOR_BORDER  = color.RGBA{0x1e, 0x29, 0x3b, 0xff}
+	COLOR_OUTLINE = color.RGBA{0x1f, 0x29, 0x37, 0xff}
+	COLOR_BLOCK   = color.RGBA{0x47, 0x55, 0x69, 0xff}
+	COLOR_HEALTH  = [3]color.RGBA{{0xff, 0x00, 0x00, 0xff}, {0xff, 0xff, 0x00, 0xff}, {0x00, 0xff, 0x00, 0xff}}
+	COLOR_CELLS   = [2][2]color.RGBA{
+		{hsl(140, 75, 35), hsl(140, 70, 45)}, // green
+		{hsl(200, 90, 35), hsl(200, 80, 45)}, // blue
+	}
+)
+
+func hsl(h, s, l float64) color.RGBA {
+	// <<<
+	s, l = s/100, l/100
+	f := func(n float64) uint8 {
+		k := n + h/30
+		for k >= 12 {
+			k -= 12
+		}
+		a := s * min(l, 1-l)
+		return uint8(255 * (l - a*max(-1, min(k-3, 9-k, 1))))
+	}
+	return color.RGBA{f(0), f(8), f(4), 0xff}
+	// >>>
+}
+
+func render_palette() color.Palette {
+	// <<<
+	palette := color.Palette{color.Black, COLOR_BORDER, COLOR_OUTLINE, COLOR_BLOCK}
+	for _, c := range COLOR_HEALTH {
+		palette = append(palette, c)
+	}
+	for _, half := range COLOR_CELLS {
+		palette = append(palette, half[0], half[1])
+	}
+	for _, e := range ELEMENTS {
+		palette = append(palette, COLORS[e][0], COLORS[e][1])
+	}
+	return palette
+	// >>>
+}
+
+func fill_rect(img *image.Paletted, x0, y0, x1, y1 int, c color.Color) {
+	// <<<
+	index := uint8(img.Palette.Index(c))
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			img.SetColorIndex(x, y, index)
+		}
+	}
+	// >>>
+}
+
+func fill_disc(img *image.Paletted, cx, cy, r int, c color.Color) {
+	// <<<
+	index := uint8(img.Palette.Index(c))
+	for y := cy - r; y <= cy+r; y++ {
+		for x := cx - r; x <= cx+r; x++ {
+			if (x-cx)*(x-cx)+(y-cy)*(y-cy) <= r*r {
+				img.SetColorIndex(x, y, index)
+			}
+		}
+	}
+	// >>>
+}
+
+func render_game(rules Ruleset, game Game, cell int) *image.Paletted {
+	// <<<
+	size := len(game.Board)
+	img := image.NewPaletted(image.Rect(0, 0, size*cell, size*cell), render_palette())
+
+	for row := 0; row < size; row++ {
+		for col := 0; col < size; col++ {
+			x, y := col*cell, row*cell
+			c := game.Board[row][col]
+
+			background := COLOR_CELLS[row/(size/2)][(row+col)%2]
+			if c.Type == BLOCK {
+				background = COLOR_BLOCK
+			}
+			fill_rect(img, x, y, x+cell, y+cell, COLOR_BORDER)
+			fill_rect(img, x+1, y+1, x+cell-1, y+cell-1, background)
+
+			if c.Type != ELEMENTAL {
+				continue
+			}
+
+			// from 0.7 at level 1 to 0.9 at the top level, like MULTS in
+			// client.js for the three classic levels
+			mult := 0.9
+			if levels := max_level(rules); levels > 1 {
+				mult = 0.7 + 0.2*float64(clamp(c.Level, 1, levels)-1)/float64(levels-1)
+			}
+			r := int(float64(cell) * 0.4 * mult)
+			cx, cy := x+cell/2, y+cell/2-cell/10
+			fill_disc(img, cx, cy, r+1, COLOR_OUTLINE)
+			fill_disc(img, cx, cy, r, COLORS[c.Element][1])
+			fill_disc(img, cx, cy, r*3/5, COLORS[c.Element][0])
+
+			max_health := rules.Health[c.Level-1]
+			bar_x0, bar_x1 := x+cell/6, x+cell-cell/6
+			bar_y0, bar_y1 := y+cell-cell/5, y+cell-cell/10
+			health := COLOR_HEALTH[2]
+			if 3*c.Health <= max_health {
+				health = COLOR_HEALTH[0]
+			} else if 2*c.Health <= max_health {
+				health = COLOR_HEALTH[1]
+			}
+			fill_rect(img, bar_x0-1, bar_y0-1, bar_x1+1, bar_y1+1, COLOR_OUTLINE)
+			fill_rect(img, bar_x0, bar_y0, bar_x0+(bar_x1-bar_x0)*c.Health/max_health, bar_y1, health)
+		}
+	}
+
+	return img
+	// >>>
+}
+
+// =============================================================================
+
+func render_request(w http.ResponseWriter, r *http.Request) (lobby_id string, gw GameWrapper, cell int, ok bool) {
+	// <<<
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	lobby_id = strings.ToUpper(r.URL.Query().Get("lobby_id"))
+	games.RLock()
+	gw, ok = games.m[lobby_id]
+	games.RUnlock()
+
+	if !ok {
+		http.Error(w, "Invalid Lobby ID", http.StatusBadRequest)
+		return
+	}
+
+	cell = RENDER_CELL_DEFAULT
+	if v, err := strconv.Atoi(r.URL.Query().Get("cell")); err == nil {
+		cell = clamp(v, RENDER_CELL_MIN, RENDER_CELL_MAX)
+	}
+	return lobby_id, gw, cell, true
+	// >>>
+}
+
+func handle_board_png(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	_, gw, cell, ok := render_request(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	png.Encode(w, render_game(gw.Rules, gw.Game, cell))
+	// >>>
+}
+
+func handle_history_gif(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	_, gw, cell, ok := render_request(w, r)
+	if !ok {
+		return
+	}
+
+	delay := 60 // 100ths of a second
+	if v, err := strconv.Atoi(r.URL.Query().Get("delay")); err == nil {
+		delay = clamp(v, 10, 500)
+	}
+
+	animation := gif.GIF{}
+	add_frame := func(game Game, delay int) {
+		animation.Image = append(animation.Image, render_game(gw.Rules, game, cell))
+		animation.Delay = append(animation.Delay, delay)
+	}
+	last, err := replay(gw, func(before GameWrapper, _ Action) {
+		add_frame(before.Game, delay)
+	})
+	if err != nil {
+		http.Error(w, "Error replaying game: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	add_frame(last.Game, delay*4)
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-cache")
+	gif.EncodeAll(w, &animation)
+	// >>>
+}
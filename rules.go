@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Every lobby plays by the Ruleset in its GameWrapper, chosen by name from
+// RULESETS when the lobby is created, so variants can run side by side on one
+// server. Tables indexed by level have one entry per level, the last one is
+// the highest level and never merges any further. Boards are Size by Size
+// cells, Size is even and every player owns one half. Traits and Advantage
+// are optional, see traits.go.
+
+type Ruleset struct {
+	// <<<
+	Name        string                      `json:"name"`
+	Size        int                         `json:"size"`         // rows and columns of the board
+	Health      []int                       `json:"health"`       // by level
+	Damage      []int                       `json:"damage"`       // by level
+	Reach       []int                       `json:"reach"`        // by level
+	Areas       []AreaShape                 `json:"areas"`        // of attacks by level, see attack
+	Charges     []int                       `json:"charges"`      // by spell, in the order of SPELLS
+	SpellDamage []int                       `json:"spell_damage"` // by spell, -1 for spells without damage
+	MergeShapes []MergeShape                `json:"merge_shapes"` // see merge.go
+	Elementals  [2]int                      `json:"elementals"`   // range of the number of elementals per side
+	Combo       []int                       `json:"combo"`        // multiplier of the charges by step of a merge cascade, the last one for all further steps
+	Traits      map[Element]ElementTrait    `json:"traits"`       // by element, nil for none
+	Advantage   map[Element]map[Element]int `json:"advantage"`    // added to the damage of attacks by attacker and target element
+	// >>>
+}
+
+// a ruleset as clients see it
+type RulesInfo struct {
+	// <<<
+	Ruleset
+	Levels  int                  `json:"levels"`
+	Spells  []SpellInfo          `json:"spells"`
+	Effects [EFFECT_COUNT]string `json:"effects"` // the order of Cell.Status
+	// >>>
+}
+
+var CLASSIC = Ruleset{
+	// <<<
+	Name:        "classic",
+	Size:        12,
+	Health:      []int{1, 2, 6},
+	Damage:      []int{1, 2, 4},
+	Reach:       []int{3, 5, 7},
+	Areas:       []AreaShape{AREA_CELL, AREA_CELL, AREA_CELL},
+	Charges:     []int{4, 5, 7, 9, 10, 6, 5, 6, 8, 5, 5},
+	SpellDamage: []int{2, -1, 1, -1, 4, -1, -1, -1, -1, -1, -1},
+	MergeShapes: MERGE_LINES,
+	Elementals:  [2]int{15, 35},
+	Combo:       []int{1, 2, 3},
+	// >>>
+}
+
+var RULESETS = []Ruleset{
+	// <<<
+	CLASSIC,
+	{
+		Name:        "blitz", // hits harder and reaches further, spells come sooner
+		Size:        12,
+		Health:      []int{1, 2, 4},
+		Damage:      []int{1, 3, 5},
+		Reach:       []int{4, 6, 8},
+		Areas:       []AreaShape{AREA_CELL, AREA_CELL, AREA_CELL},
+		Charges:     []int{3, 4, 5, 7, 8, 5, 4, 5, 6, 4, 4},
+		SpellDamage: []int{2, -1, 1, -1, 4, -1, -1, -1, -1, -1, -1},
+		MergeShapes: MERGE_LINES,
+		Elementals:  [2]int{12, 24},
+		Combo:       []int{1, 2, 3},
+	},
+	{
+		Name:        "fortress", // tougher elementals on a fuller board
+		Size:        12,
+		Health:      []int{2, 4, 8},
+		Damage:      []int{1, 2, 4},
+		Reach:       []int{3, 4, 6},
+		Areas:       []AreaShape{AREA_CELL, AREA_CELL, AREA_CELL},
+		Charges:     []int{5, 6, 8, 10, 12, 7, 6, 7, 9, 6, 6},
+		SpellDamage: []int{3, -1, 2, -1, 5, -1, -1, -1, -1, -1, -1},
+		MergeShapes: MERGE_LINES,
+		Elementals:  [2]int{20, 40},
+		Combo:       []int{1, 2, 3},
+	},
+	{
+		Name:        "lines", // no diagonal merges
+		Size:        12,
+		Health:      []int{1, 2, 6},
+		Damage:      []int{1, 2, 4},
+		Reach:       []int{3, 5, 7},
+		Areas:       []AreaShape{AREA_CELL, AREA_CELL, AREA_CELL},
+		Charges:     []int{4, 5, 7, 9, 10, 6, 5, 6, 8, 5, 5},
+		SpellDamage: []int{2, -1, 1, -1, 4, -1, -1, -1, -1, -1, -1},
+		MergeShapes: MERGE_LINES[:2],
+		Elementals:  [2]int{15, 35},
+		Combo:       []int{1, 2, 3},
+	},
+	{
+		Name:        "elements", // classic with a trait per element, every element is strong against another
+		Size:        12,
+		Health:      []int{1, 2, 6},
+		Damage:      []int{1, 2, 4},
+		Reach:       []int{3, 5, 7},
+		Areas:       []AreaShape{AREA_CELL, AREA_CELL, AREA_CELL},
+		Charges:     []int{4, 5, 7, 9, 10, 6, 5, 6, 8, 5, 5},
+		SpellDamage: []int{2, -1, 1, -1, 4, -1, -1, -1, -1, -1, -1},
+		MergeShapes: MERGE_LINES,
+		Elementals:  [2]int{15, 35},
+		Combo:       []int{1, 2, 3},
+		Traits: map[Element]ElementTrait{
+			AIR:    {TRAIT_WIDE, 1},
+			ROCK:   {TRAIT_ARMOR, 1},
+			FIRE:   {TRAIT_SPLASH, 1},
+			WATER:  {TRAIT_HEAL, 1},
+			NATURE: {TRAIT_THORNS, 1},
+			ENERGY: {TRAIT_REACH, 2},
+		},
+		Advantage: map[Element]map[Element]int{ // fire > nature > water > fire, air > rock > energy > air
+			FIRE:   {NATURE: 1},
+			NATURE: {WATER: 1},
+			WATER:  {FIRE: 1},
+			AIR:    {ROCK: 1},
+			ROCK:   {ENERGY: 1},
+			ENERGY: {AIR: 1},
+		},
+	},
+	{
+		Name:        "shapes", // classic with corners, squares and fours merging too
+		Size:        12,
+		Health:      []int{1, 2, 6},
+		Damage:      []int{1, 2, 4},
+		Reach:       []int{3, 5, 7},
+		Areas:       []AreaShape{AREA_CELL, AREA_CELL, AREA_CELL},
+		Charges:     []int{4, 5, 7, 9, 10, 6, 5, 6, 8, 5, 5},
+		SpellDamage: []int{2, -1, 1, -1, 4, -1, -1, -1, -1, -1, -1},
+		MergeShapes: slices.Concat(MERGE_FOURS, MERGE_SQUARE, MERGE_LINES, MERGE_CORNERS),
+		Elementals:  [2]int{15, 35},
+		Combo:       []int{1, 2, 3},
+	},
+	{
+		Name:        "ascension", // a fourth level which hits the neighbours of its target too
+		Size:        12,
+		Health:      []int{1, 2, 6, 12},
+		Damage:      []int{1, 2, 4, 6},
+		Reach:       []int{3, 5, 7, 9},
+		Areas:       []AreaShape{AREA_CELL, AREA_CELL, AREA_CELL, AREA_SQUARE},
+		Charges:     []int{4, 5, 7, 9, 10, 6, 5, 6, 8, 5, 5},
+		SpellDamage: []int{2, -1, 1, -1, 4, -1, -1, -1, -1, -1, -1},
+		MergeShapes: MERGE_LINES,
+		Elementals:  [2]int{15, 35},
+		Combo:       []int{1, 2, 3},
+	},
+	{
+		Name:        "quick", // a small board for short games
+		Size:        8,
+		Health:      []int{1, 2, 6},
+		Damage:      []int{1, 2, 4},
+		Reach:       []int{2, 3, 4},
+		Areas:       []AreaShape{AREA_CELL, AREA_CELL, AREA_CELL},
+		Charges:     []int{3, 4, 5, 6, 7, 4, 4, 4, 5, 4, 4},
+		SpellDamage: []int{2, -1, 1, -1, 4, -1, -1, -1, -1, -1, -1},
+		MergeShapes: MERGE_LINES,
+		Elementals:  [2]int{7, 15},
+		Combo:       []int{1, 2, 3},
+	},
+	{
+		Name:        "epic", // a large board for long games
+		Size:        16,
+		Health:      []int{1, 2, 6},
+		Damage:      []int{1, 2, 4},
+		Reach:       []int{4, 6, 9},
+		Areas:       []AreaShape{AREA_CELL, AREA_CELL, AREA_CELL},
+		Charges:     []int{5, 6, 8, 10, 12, 7, 6, 7, 9, 6, 6},
+		SpellDamage: []int{2, -1, 1, -1, 4, -1, -1, -1, -1, -1, -1},
+		MergeShapes: MERGE_LINES,
+		Elementals:  [2]int{27, 62},
+		Combo:       []int{1, 2, 3},
+	},
+	// >>>
+}
+
+// "" is CLASSIC
+func find_ruleset(name string) (Ruleset, error) {
+	// <<<
+	if name == "" {
+		return CLASSIC, nil
+	}
+	for _, rules := range RULESETS {
+		if rules.Name == name {
+			return rules, nil
+		}
+	}
+	return Ruleset{}, fmt.Errorf("invalid ruleset %q", name)
+	// >>>
+}
+
+// the ruleset of options which passed validate_options
+func options_ruleset(options LobbyOptions) Ruleset {
+	// <<<
+	rules, err := find_ruleset(options.Rules)
+	if err != nil {
+		return CLASSIC
+	}
+	return rules
+	// >>>
+}
+
+func validate_ruleset(rules Ruleset) error {
+	// <<<
+	if rules.Size < 6 || rules.Size%2 != 0 {
+		return fmt.Errorf("the size must be even and at least 6")
+	}
+	levels := len(rules.Health)
+	if levels < 1 || len(rules.Damage) != levels || len(rules.Reach) != levels || len(rules.Areas) != levels {
+		return fmt.Errorf("health, damage, reach and areas need one entry per level")
+	}
+	if levels > 9 {
+		return fmt.Errorf("the position notation has room for 9 levels")
+	}
+	for _, area := range rules.Areas {
+		if !slices.Contains([]AreaShape{AREA_CELL, AREA_CROSS, AREA_SQUARE}, area) {
+			return fmt.Errorf("invalid attack area %q", area)
+		}
+	}
+	if len(rules.Charges) != len(SPELLS) || len(rules.SpellDamage) != len(SPELLS) {
+		return fmt.Errorf("charges and spell damage need one entry per spell")
+	}
+	for _, v := range rules.Health {
+		if v < 1 {
+			return fmt.Errorf("health must be positive")
+		}
+	}
+	for _, v := range rules.Charges {
+		if v < 1 {
+			return fmt.Errorf("charges must be positive")
+		}
+	}
+	if len(rules.Combo) < 1 || slices.Min(rules.Combo) < 1 {
+		return fmt.Errorf("the combo needs a positive multiplier per cascade step")
+	}
+	if len(rules.MergeShapes) == 0 {
+		return fmt.Errorf("there must be a merge shape")
+	}
+	for _, shape := range rules.MergeShapes {
+		if len(shape.Cells) < 3 || shape.Ascend < 0 || shape.Ascend >= len(shape.Cells) || shape.Bonus < 0 {
+			return fmt.Errorf("merge shape %q needs three cells, one of them ascending", shape.Name)
+		}
+		for i, d := range shape.Cells {
+			if d[0] < 0 || d[1] < 0 || d[0] >= rules.Size || d[1] >= rules.Size/2 {
+				return fmt.Errorf("merge shape %q must fit into a half right of and below its anchor", shape.Name)
+			}
+			if slices.Contains(shape.Cells[:i], d) {
+				return fmt.Errorf("merge shape %q has a cell twice", shape.Name)
+			}
+		}
+	}
+	if err := validate_traits(rules); err != nil {
+		return err
+	}
+	if rules.Elementals[0] < 0 || rules.Elementals[1] < rules.Elementals[0] || rules.Elementals[1] > rules.Size*rules.Size/2 {
+		return fmt.Errorf("the elementals range must fit into a half")
+	}
+	return nil
+	// >>>
+}
+
+func max_level(rules Ruleset) int {
+	return len(rules.Health)
+}
+
+func init() {
+	// <<<
+	for _, rules := range RULESETS {
+		if err := validate_ruleset(rules); err != nil {
+			panic(fmt.Sprintf("ruleset %v: %v", rules.Name, err))
+		}
+	}
+	// >>>
+}
+
+func rules_info(rules Ruleset) RulesInfo {
+	// <<<
+	return RulesInfo{Ruleset: rules, Levels: max_level(rules), Spells: spell_infos(rules), Effects: EFFECT_NAMES}
+	// >>>
+}
+
+func random_elemental_count(rules Ruleset, rng *rand.Rand) int {
+	return rules.Elementals[0] + rng.Intn(rules.Elementals[1]-rules.Elementals[0]+1)
+}
+
+// =============================================================================
+
+// the rules of a lobby with ?lobby_id=, of a preset with ?name=, else of
+// every preset
+func handle_rules(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var response any
+	query := r.URL.Query()
+	switch {
+	case query.Has("lobby_id"):
+		games.RLock()
+		gw, ok := games.m[strings.ToUpper(query.Get("lobby_id"))]
+		games.RUnlock()
+		if !ok {
+			http.Error(w, "Invalid Lobby ID", http.StatusBadRequest)
+			return
+		}
+		response = rules_info(gw.Rules)
+	case query.Has("name"):
+		rules, err := find_ruleset(query.Get("name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response = rules_info(rules)
+	default:
+		list := make([]RulesInfo, 0, len(RULESETS))
+		for _, rules := range RULESETS {
+			list = append(list, rules_info(rules))
+		}
+		response = list
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	// >>>
+}
@@ -1,4 +1,4 @@
 #!/usr/bin/bash
 
-wgo -file=go clear :: go run main.go
+wgo -file=go clear :: go run .
 # wgo -file=go -file=js -file=html -file=css clear :: go run .
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Sandbox lobbies start from an empty board (or a given position) and let
+// their owner, the player who created them, edit cells, charges and the
+// active player until the first action is played. Every edit is checked with
+// validate_game before it is stored.
+
+type CellEdit struct {
+	// <<<
+	Row     int               `json:"row"`
+	Col     int               `json:"col"`
+	Type    CellType          `json:"type"`
+	Element Element           `json:"element"`
+	Level   int               `json:"level"`
+	Health  int               `json:"health"` // 0 means full health
+	Status  [EFFECT_COUNT]int `json:"status"` // turns left by effect
+	// >>>
+}
+
+func apply_edit(rules Ruleset, game *Game, edit CellEdit) error {
+	// <<<
+	if !valid(game.Board, edit.Row, edit.Col) {
+		return fmt.Errorf("cell %v,%v is outside of the board", edit.Row, edit.Col)
+	}
+
+	cell := &game.Board[edit.Row][edit.Col]
+	cell.Type = edit.Type
+	cell.Element, cell.Level, cell.Health, cell.Status = "", 0, 0, [EFFECT_COUNT]int{}
+	if edit.Type == ELEMENTAL {
+		cell.Element = edit.Element
+		cell.Level = edit.Level
+		cell.Health = edit.Health
+		cell.Status = edit.Status
+		if edit.Health == 0 && edit.Level >= 1 && edit.Level <= max_level(rules) {
+			cell.Health = rules.Health[edit.Level-1]
+		}
+	}
+	return nil
+	// >>>
+}
+
+// =============================================================================
+
+func handle_new_sandbox(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		PlayerID string       `json:"player_id"`
+		Position string       `json:"position"` // optional
+		Options  LobbyOptions `json:"options"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate_options(data.Options); err != nil {
+		http.Error(w, "Invalid Options: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if data.Options.Draft || data.Options.Placement || data.Options.Layout != "" || data.Options.Fair {
+		http.Error(w, "Invalid Options: drafts, placements and layouts need a new board", http.StatusBadRequest)
+		return
+	}
+
+	rules := options_ruleset(data.Options)
+	game, skip_advance := make_empty_game(rules), 0
+	if data.Position != "" {
+		game, skip_advance, err = parse_position(rules, data.Position)
+		if err != nil {
+			http.Error(w, "Invalid Position: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	gw := make_lobby(game, data.PlayerID, data.Options)
+	gw.SkipAdvance = skip_advance
+	gw.Sandbox = true
+	lobby_id := add_lobby(gw)
+
+	response := struct {
+		LobbyID        string  `json:"lobby_id"`
+		GameSOA        GameSOA `json:"game_soa"`
+		PlayerIndex    int     `json:"player_index"`
+		ReconnectToken string  `json:"reconnect_token"`
+	}{
+		LobbyID:        lobby_id,
+		GameSOA:        aos2soa(gw.Game),
+		PlayerIndex:    seat_of(gw, data.PlayerID),
+		ReconnectToken: reconnect_token(gw, data.PlayerID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	// >>>
+}
+
+func handle_sandbox_edit(w http.ResponseWriter, r *http.Request) {
+	// <<<
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var data struct {
+		LobbyID      string                    `json:"lobby_id"`
+		PlayerID     string                    `json:"player_id"`
+		Cells        []CellEdit                `json:"cells"`
+		Players      *[2][LOADOUT_SIZE]int     `json:"players"`       // optional
+		Loadouts     *[2][LOADOUT_SIZE]SpellID `json:"loadouts"`      // optional
+		ActivePlayer *int                      `json:"active_player"` // optional
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		http.Error(w, "Error decoding JSON", http.StatusBadRequest)
+		return
+	}
+
+	games.Lock()
+	defer games.Unlock()
+
+	gw, ok := games.m[data.LobbyID]
+	if !ok {
+		http.Error(w, "Invalid Lobby ID", http.StatusBadRequest)
+		return
+	}
+	if !gw.Sandbox || data.PlayerID == "" || gw.Owner != data.PlayerID {
+		http.Error(w, "Only the owner of a sandbox can edit it", http.StatusBadRequest)
+		return
+	}
+	if len(gw.History) > 0 {
+		http.Error(w, "The game has already started", http.StatusBadRequest)
+		return
+	}
+
+	game := gw.Game
+	game.Board = copy_board(gw.Game.Board)
+	for _, edit := range data.Cells {
+		if err := apply_edit(gw.Rules, &game, edit); err != nil {
+			http.Error(w, "Invalid Edit: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if data.Loadouts != nil {
+		game.Loadouts = *data.Loadouts
+	}
+	if data.Players != nil {
+		game.Players = *data.Players
+	}
+	if data.ActivePlayer != nil {
+		game.ActivePlayer = *data.ActivePlayer
+	}
+	if err := validate_game(gw.Rules, game); err != nil {
+		http.Error(w, "Invalid Game: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	gw.Game = game
+	gw.First = game.ActivePlayer
+	gw.Start = format_position(gw.Game, gw.SkipAdvance)
+	gw.LastAccessedAt = time.Now()
+	games.m[data.LobbyID] = gw
+
+	response := struct {
+		Ok       bool    `json:"ok"`
+		GameSOA  GameSOA `json:"game_soa"`
+		Position string  `json:"position"`
+	}{
+		Ok:       true,
+		GameSOA:  aos2soa(gw.Game),
+		Position: gw.Start,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	// >>>
+}
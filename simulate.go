@@ -0,0 +1,484 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Self-play balance simulator. Two bots play each other under a ruleset and
+// under the same ruleset with the balance tables from the command line, and
+// the results are summed up so the tables of a Ruleset can be tuned from
+// evidence.
+//
+//	go run . -simulate 5000 -seed 1 -rules blitz -damage 1,2,3 -reach 3,5,6
+
+var sim_flags = struct {
+	// <<<
+	games        *int
+	seed         *int64
+	rules        *string
+	health       *string
+	damage       *string
+	reach        *string
+	charges      *string
+	spell_damage *string
+	combo        *string
+	layout       *string
+	fair         *bool
+	loadouts     *string
+	// >>>
+}{
+	// <<<
+	games:        flag.Int("simulate", 0, "play N bot games per table set and print balance statistics instead of serving"),
+	seed:         flag.Int64("seed", 1, "seed for the simulator"),
+	rules:        flag.String("rules", "", "ruleset for the simulator, classic by default"),
+	health:       flag.String("health", "", "alternate health table, e.g. 1,2,6"),
+	damage:       flag.String("damage", "", "alternate damage table, e.g. 1,2,4"),
+	reach:        flag.String("reach", "", "alternate reach table, e.g. 3,5,7"),
+	charges:      flag.String("charges", "", "alternate charges table, e.g. 4,5,7,9,10,6,5,6,8,5,5"),
+	spell_damage: flag.String("spell-damage", "", "alternate spell damage table, e.g. 2,-1,1,-1,4,-1,-1,-1,-1,-1,-1"),
+	combo:        flag.String("combo", "", "alternate combo table, e.g. 1,2,3"),
+	layout:       flag.String("layout", "", "board layout for the simulator: random, mirror or rotate"),
+	fair:         flag.Bool("fair", false, "simulate only boards which pass the fairness scorer"),
+	loadouts:     flag.String("loadouts", "", "loadouts of the bots: default or random"),
+	// >>>
+}
+
+const (
+	SIM_MAX_ACTIONS = 1000
+	SIM_MOVE_TRIES  = 24
+)
+
+type SimReport struct {
+	// <<<
+	Games        int
+	Wins         [2]int // by seat, seat 0 always moves first
+	Draws        int
+	Turns        int
+	Actions      int
+	SpellsCast   map[SpellID]int
+	Cascades     [4]int // merge cascades by steps, the last one for 4 steps and more
+	ElementGames map[Element]int
+	ElementWins  map[Element]int
+	// >>>
+}
+
+// =============================================================================
+
+func parse_table(s string, fallback []int) ([]int, error) {
+	// <<<
+	if s == "" {
+		return fallback, nil
+	}
+	fields := strings.Split(s, ",")
+	if len(fields) != len(fallback) {
+		return nil, fmt.Errorf("expected %v values, got %v in %q", len(fallback), len(fields), s)
+	}
+	table := make([]int, len(fields))
+	for i, f := range fields {
+		v, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q in %q", f, s)
+		}
+		table[i] = v
+	}
+	return table, nil
+	// >>>
+}
+
+// the base ruleset with the tables from the command line
+func rules_from_flags(base Ruleset) (Ruleset, bool, error) {
+	// <<<
+	t := base
+	t.Name = base.Name + " (alternate)"
+	var err error
+
+	if t.Health, err = parse_table(*sim_flags.health, base.Health); err != nil {
+		return t, false, fmt.Errorf("-health: %w", err)
+	}
+	if t.Damage, err = parse_table(*sim_flags.damage, base.Damage); err != nil {
+		return t, false, fmt.Errorf("-damage: %w", err)
+	}
+	if t.Reach, err = parse_table(*sim_flags.reach, base.Reach); err != nil {
+		return t, false, fmt.Errorf("-reach: %w", err)
+	}
+	if t.Charges, err = parse_table(*sim_flags.charges, base.Charges); err != nil {
+		return t, false, fmt.Errorf("-charges: %w", err)
+	}
+	if t.SpellDamage, err = parse_table(*sim_flags.spell_damage, base.SpellDamage); err != nil {
+		return t, false, fmt.Errorf("-spell-damage: %w", err)
+	}
+	if t.Combo, err = parse_table(*sim_flags.combo, base.Combo); err != nil {
+		return t, false, fmt.Errorf("-combo: %w", err)
+	}
+	if err := validate_ruleset(t); err != nil {
+		return t, false, err
+	}
+
+	changed := *sim_flags.health != "" || *sim_flags.damage != "" || *sim_flags.reach != "" ||
+		*sim_flags.charges != "" || *sim_flags.spell_damage != "" || *sim_flags.combo != ""
+	return t, changed, nil
+	// >>>
+}
+
+// =============================================================================
+
+func enemy_elementals(board Board, player_index int) []Pos {
+	// <<<
+	result := []Pos{}
+	for i := range board {
+		for j := range board[i] {
+			if board[i][j].Type == ELEMENTAL && owner(board, i) != player_index {
+				result = append(result, Pos{i, j})
+			}
+		}
+	}
+	return result
+	// >>>
+}
+
+func own_elementals(board Board, player_index int) []Pos {
+	return enemy_elementals(board, 1-player_index)
+}
+
+// empty cells reachable through empty cells within the same half (see get_path in client.js)
+func reachable(board Board, from Pos) []Pos {
+	// <<<
+	result := []Pos{}
+	visited := make_grid[bool](len(board))
+	visited[from.Row][from.Col] = true
+	queue := []Pos{from}
+	side := owner(board, from.Row)
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, d := range [4]Pos{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+			next := Pos{current.Row + d.Row, current.Col + d.Col}
+			if !valid(board, next.Row, next.Col) || owner(board, next.Row) != side ||
+				visited[next.Row][next.Col] || board[next.Row][next.Col].Type != EMPTY {
+				continue
+			}
+			visited[next.Row][next.Col] = true
+			result = append(result, next)
+			queue = append(queue, next)
+		}
+	}
+
+	return result
+	// >>>
+}
+
+func spell_ready(gw GameWrapper, player_index, slot int) bool {
+	charges := loadout_charges(gw.Rules, gw.Game.Loadouts[player_index])
+	return gw.PlayerCanUseSpell[player_index] && gw.Game.Players[player_index][slot] >= charges[slot]
+}
+
+func random_loadout(rng *rand.Rand) [LOADOUT_SIZE]SpellID {
+	// <<<
+	loadout := [LOADOUT_SIZE]SpellID{}
+	for i, j := range rng.Perm(len(SPELLS))[:LOADOUT_SIZE] {
+		loadout[i] = SPELLS[j]
+	}
+	return loadout
+	// >>>
+}
+
+func bot_spell(gw GameWrapper, rng *rand.Rand) (Action, bool) {
+	// <<<
+	p := gw.Game.ActivePlayer
+	ready := []int{}
+	for i := range gw.Game.Loadouts[p] {
+		if spell_ready(gw, p, i) {
+			ready = append(ready, i)
+		}
+	}
+	if len(ready) == 0 || rng.Intn(2) == 0 {
+		return Action{}, false
+	}
+
+	spell := gw.Game.Loadouts[p][ready[rng.Intn(len(ready))]]
+	action := Action{Type: SPELL, Spell: spell}
+	switch SPELL_REGISTRY[spell].info().Target {
+	case TARGET_NONE:
+		return action, true
+	case TARGET_OWN:
+		damaged := []Pos{}
+		for _, pos := range own_elementals(gw.Game.Board, p) {
+			cell := gw.Game.Board[pos.Row][pos.Col]
+			if cell.Health < gw.Rules.Health[cell.Level-1] {
+				damaged = append(damaged, pos)
+			}
+		}
+		if len(damaged) == 0 {
+			return Action{}, false
+		}
+		action.To = damaged[rng.Intn(len(damaged))]
+	case TARGET_OWN_PAIR:
+		own := own_elementals(gw.Game.Board, p)
+		if len(own) < 2 {
+			return Action{}, false
+		}
+		i, j := rng.Intn(len(own)), rng.Intn(len(own)-1)
+		if j >= i {
+			j += 1
+		}
+		action.From, action.To = own[i], own[j]
+	case TARGET_OWN_EMPTY:
+		targets := []Pos{}
+		for row := range gw.Game.Board {
+			for col := range gw.Game.Board[row] {
+				action.To = Pos{row, col}
+				if SPELL_REGISTRY[spell].check(gw, p, action) == nil {
+					targets = append(targets, action.To)
+				}
+			}
+		}
+		if len(targets) == 0 {
+			return Action{}, false
+		}
+		action.To = targets[rng.Intn(len(targets))]
+	default:
+		targets := enemy_elementals(gw.Game.Board, p)
+		if len(targets) == 0 {
+			return Action{}, false
+		}
+		action.To = targets[rng.Intn(len(targets))]
+	}
+	return action, true
+	// >>>
+}
+
+// prefers kills, then the hardest hit
+func bot_attack(gw GameWrapper, rng *rand.Rand) (Action, bool) {
+	// <<<
+	board := gw.Game.Board
+	p := gw.Game.ActivePlayer
+	best, best_score := Action{}, -1
+
+	for _, from := range own_elementals(board, p) {
+		for _, to := range enemy_elementals(board, p) {
+			if !can_attack(gw.Rules, board, from.Row, from.Col, to.Row, to.Col) {
+				continue
+			}
+			damage := armor(gw.Rules, board[to.Row][to.Col], attack_damage(gw.Rules, board[from.Row][from.Col], board[to.Row][to.Col]))
+			score := damage*4 + rng.Intn(4)
+			if damage >= board[to.Row][to.Col].Health {
+				score += 100 * board[to.Row][to.Col].Level
+			}
+			if score > best_score {
+				best, best_score = Action{Type: ATTACK, From: from, To: to}, score
+			}
+		}
+	}
+
+	return best, best_score >= 0
+	// >>>
+}
+
+// samples a few moves and prefers those which merge or open an attack
+func bot_move(gw GameWrapper, rng *rand.Rand) (Action, bool) {
+	// <<<
+	board := gw.Game.Board
+	p := gw.Game.ActivePlayer
+	pieces := own_elementals(board, p)
+	if len(pieces) == 0 {
+		return Action{}, false
+	}
+
+	best, best_score := Action{}, -1
+	for try := 0; try < SIM_MOVE_TRIES; try++ {
+		from := pieces[rng.Intn(len(pieces))]
+		targets := reachable(board, from)
+		if len(targets) == 0 {
+			continue
+		}
+		to := targets[rng.Intn(len(targets))]
+
+		next := copy_board(board)
+		next[to.Row][to.Col], next[from.Row][from.Col] = next[from.Row][from.Col], next[to.Row][to.Col]
+		score := rng.Intn(3)
+		if able_to_attack(gw.Rules, next, to.Row, to.Col) {
+			score += 5
+		}
+		score += 20 * merge_board(gw.Rules, &next, 1-p)
+
+		if score > best_score {
+			best, best_score = Action{Type: MOVE, From: from, To: to}, score
+		}
+	}
+
+	return best, best_score >= 0
+	// >>>
+}
+
+func bot_action(gw GameWrapper, rng *rand.Rand) Action {
+	// <<<
+	if action, ok := bot_spell(gw, rng); ok {
+		return action
+	}
+	if action, ok := bot_attack(gw, rng); ok {
+		return action
+	}
+	if action, ok := bot_move(gw, rng); ok {
+		return action
+	}
+	return Action{Type: SKIP}
+	// >>>
+}
+
+// =============================================================================
+
+func side_elements(board Board) [2][]Element {
+	// <<<
+	result := [2][]Element{}
+	for i := range board {
+		for j := range board[i] {
+			cell := board[i][j]
+			if p := owner(board, i); cell.Type == ELEMENTAL && !slices.Contains(result[p], cell.Element) {
+				result[p] = append(result[p], cell.Element)
+			}
+		}
+	}
+	return result
+	// >>>
+}
+
+func simulate_game(rules Ruleset, rng *rand.Rand, report *SimReport) {
+	// <<<
+	gw := GameWrapper{
+		Game:              generate_game(rules, rng, random_sides(rng), Layout(*sim_flags.layout), *sim_flags.fair),
+		Rules:             rules,
+		Players:           []string{"bot0", "bot1"},
+		PlayerCanUseSpell: []bool{true, true},
+	}
+	elements := side_elements(gw.Game.Board)
+	if *sim_flags.loadouts == "random" {
+		for p := 0; p < 2; p++ {
+			gw.Game.Loadouts[p] = random_loadout(rng)
+			gw.Game.Players[p] = loadout_charges(rules, gw.Game.Loadouts[p])
+		}
+	}
+
+	over, winner := false, -1
+	actions := 0
+	max_turn := rules.Size * rules.Size // block_board runs out of rows after this
+	for ; actions < SIM_MAX_ACTIONS && gw.Game.Turn < max_turn; actions++ {
+		if over, winner = game_winner(gw.Game); over {
+			break
+		}
+		p := gw.Game.ActivePlayer
+		action := bot_action(gw, rng)
+		gw.Cascade = Cascade{}
+		if apply_action(&gw, p, action) != nil {
+			apply_action(&gw, p, Action{Type: SKIP})
+			continue
+		}
+		if action.Type == SPELL {
+			report.SpellsCast[action.Spell] += 1
+		}
+		if len(gw.Cascade.Steps) > 0 {
+			report.Cascades[min(len(gw.Cascade.Steps), len(report.Cascades))-1] += 1
+		}
+	}
+	if !over {
+		over, winner = game_winner(gw.Game) // won by the last action
+	}
+
+	report.Games += 1
+	report.Turns += gw.Game.Turn
+	report.Actions += actions
+	if !over || winner == -1 {
+		report.Draws += 1
+	} else {
+		report.Wins[winner] += 1
+	}
+	for p := 0; p < 2; p++ {
+		for _, e := range elements[p] {
+			report.ElementGames[e] += 1
+			if over && winner == p {
+				report.ElementWins[e] += 1
+			}
+		}
+	}
+	// >>>
+}
+
+func simulate(num_games int, seed int64, rules Ruleset) SimReport {
+	// <<<
+	rng := rand.New(rand.NewSource(seed))
+	report := SimReport{
+		SpellsCast:   map[SpellID]int{},
+		ElementGames: map[Element]int{},
+		ElementWins:  map[Element]int{},
+	}
+	for i := 0; i < num_games; i++ {
+		simulate_game(rules, rng, &report)
+	}
+	return report
+	// >>>
+}
+
+func percent(a, b int) float64 {
+	// <<<
+	if b == 0 {
+		return 0
+	}
+	return 100 * float64(a) / float64(b)
+	// >>>
+}
+
+func print_report(t Ruleset, r SimReport) {
+	// <<<
+	fmt.Printf("== %v ==\n", t.Name)
+	fmt.Printf("tables:       size=%v health=%v damage=%v reach=%v charges=%v spell_damage=%v combo=%v\n",
+		t.Size, t.Health, t.Damage, t.Reach, t.Charges, t.SpellDamage, t.Combo)
+	fmt.Printf("games:        %v\n", r.Games)
+	fmt.Printf("first player: %5.1f%% wins\n", percent(r.Wins[0], r.Games))
+	fmt.Printf("second:       %5.1f%% wins\n", percent(r.Wins[1], r.Games))
+	fmt.Printf("draws:        %5.1f%%\n", percent(r.Draws, r.Games))
+	fmt.Printf("advantage:    %+5.1f%% (first - second)\n", percent(r.Wins[0]-r.Wins[1], r.Games))
+	fmt.Printf("length:       %.1f turns, %.1f actions\n",
+		float64(r.Turns)/float64(max(r.Games, 1)), float64(r.Actions)/float64(max(r.Games, 1)))
+	for _, s := range SPELLS {
+		fmt.Printf("spell %v:     %.2f casts/game\n", s, float64(r.SpellsCast[s])/float64(max(r.Games, 1)))
+	}
+	fmt.Printf("cascades:     %.2f/game of 1 step, %.2f of 2, %.2f of 3, %.2f of more\n",
+		float64(r.Cascades[0])/float64(max(r.Games, 1)), float64(r.Cascades[1])/float64(max(r.Games, 1)),
+		float64(r.Cascades[2])/float64(max(r.Games, 1)), float64(r.Cascades[3])/float64(max(r.Games, 1)))
+	for _, e := range ELEMENTS {
+		fmt.Printf("%-7v       %5.1f%% wins in %v games\n", e, percent(r.ElementWins[e], r.ElementGames[e]), r.ElementGames[e])
+	}
+	fmt.Println()
+	// >>>
+}
+
+func run_simulation() error {
+	// <<<
+	base, err := find_ruleset(*sim_flags.rules)
+	if err != nil {
+		return fmt.Errorf("-rules: %w", err)
+	}
+	alternate, changed, err := rules_from_flags(base)
+	if err != nil {
+		return err
+	}
+	if err := validate_options(LobbyOptions{Layout: Layout(*sim_flags.layout)}); err != nil {
+		return fmt.Errorf("-layout: %w", err)
+	}
+	if *sim_flags.loadouts != "" && *sim_flags.loadouts != "default" && *sim_flags.loadouts != "random" {
+		return fmt.Errorf("-loadouts: expected default or random")
+	}
+
+	n, seed := *sim_flags.games, *sim_flags.seed
+	print_report(base, simulate(n, seed, base))
+	if changed {
+		print_report(alternate, simulate(n, seed, alternate))
+	}
+	return nil
+	// >>>
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Spells are looked up in SPELL_REGISTRY by their id, apply_spell checks the
+// charges and the target with the spell itself, spends the charges and
+// applies it. A new spell is a type implementing Spell, added to
+// SPELL_REGISTRY and SPELLS, with its charges and damage in every ruleset.
+//
+// Every player casts from a loadout of LOADOUT_SIZE distinct spells out of
+// SPELLS, DEFAULT_LOADOUT unless the lobby has the loadout option, and the
+// charges in Game.Players are by loadout slot.
+
+type SpellTarget string
+type AreaShape string
+
+const ( // <<<
+	TARGET_NONE      SpellTarget = "none"
+	TARGET_OWN       SpellTarget = "own"       // an elemental of the caster
+	TARGET_OWN_PAIR  SpellTarget = "own_pair"  // two elementals of the caster, from and to
+	TARGET_OWN_EMPTY SpellTarget = "own_empty" // an empty cell in the half of the caster
+	TARGET_ENEMY     SpellTarget = "enemy"     // a cell in the enemy half
+
+	AREA_NONE   AreaShape = "none"
+	AREA_CELL   AreaShape = "cell"   // the target only
+	AREA_CROSS  AreaShape = "cross"  // the row of the target and its column within the half
+	AREA_SQUARE AreaShape = "square" // the target and its neighbours within the half
+) // >>>
+
+type SpellInfo struct {
+	// <<<
+	Spell   SpellID     `json:"spell"`
+	Name    string      `json:"name"`
+	Target  SpellTarget `json:"target"`
+	Area    AreaShape   `json:"area"`
+	Charges int         `json:"charges"` // needed to cast it, from the ruleset
+	Damage  int         `json:"damage"`  // from the ruleset, -1 for spells without damage
+	// >>>
+}
+
+type Spell interface {
+	// <<<
+	info() SpellInfo
+	check(gw GameWrapper, player_index int, action Action) error
+	apply(gw *GameWrapper, player_index int, action Action) // only after check passed
+	// >>>
+}
+
+// filled before any init() runs, so the puzzles may cast spells in theirs
+var SPELL_REGISTRY = spell_registry(
+	damage_spell{SpellInfo{Spell: FS, Name: "Forest Staff", Target: TARGET_ENEMY, Area: AREA_CELL}},
+	heal_spell{SpellInfo{Spell: HV, Name: "Healing Vial", Target: TARGET_OWN, Area: AREA_CELL}},
+	damage_spell{SpellInfo{Spell: AF, Name: "Ancient Figurine", Target: TARGET_ENEMY, Area: AREA_CROSS}},
+	double_turn{SpellInfo{Spell: DT, Name: "Double Turn", Target: TARGET_NONE, Area: AREA_NONE}},
+	damage_spell{SpellInfo{Spell: MS, Name: "Meteor Shower", Target: TARGET_ENEMY, Area: AREA_SQUARE}},
+	effect_spell{SpellInfo{Spell: SH, Name: "Stone Shield", Target: TARGET_OWN, Area: AREA_CELL}, SHIELD, 3}, // through the next 2 enemy turns
+	swap_spell{SpellInfo{Spell: SW, Name: "Swap", Target: TARGET_OWN_PAIR, Area: AREA_CELL}},
+	effect_spell{SpellInfo{Spell: FZ, Name: "Frost Nova", Target: TARGET_ENEMY, Area: AREA_CELL}, STUN, 1}, // through the next enemy turn
+	summon_spell{SpellInfo{Spell: SU, Name: "Summon", Target: TARGET_OWN_EMPTY, Area: AREA_CELL}},
+	effect_spell{SpellInfo{Spell: IG, Name: "Ignite", Target: TARGET_ENEMY, Area: AREA_CELL}, BURN, 2}, // burns at the end of the next 2 enemy turns
+	effect_spell{SpellInfo{Spell: HA, Name: "Haste", Target: TARGET_OWN, Area: AREA_CELL}, HASTE, 2},   // this turn and the next own one
+)
+
+func spell_registry(spells ...Spell) map[SpellID]Spell {
+	// <<<
+	result := map[SpellID]Spell{}
+	for _, spell := range spells {
+		id := spell.info().Spell
+		if _, ok := result[id]; ok {
+			panic(fmt.Sprintf("spell %v registered twice", id))
+		}
+		result[id] = spell
+	}
+	return result
+	// >>>
+}
+
+func init() {
+	// <<<
+	for _, id := range SPELLS {
+		if _, ok := SPELL_REGISTRY[id]; !ok {
+			panic(fmt.Sprintf("spell %v is not registered", id))
+		}
+	}
+	if err := validate_loadout(DEFAULT_LOADOUT); err != nil {
+		panic(err)
+	}
+	// >>>
+}
+
+func validate_loadout(loadout [LOADOUT_SIZE]SpellID) error {
+	// <<<
+	for i, id := range loadout {
+		if !slices.Contains(SPELLS, id) {
+			return fmt.Errorf("Invalid Spell %q", id)
+		}
+		if slices.Contains(loadout[:i], id) {
+			return fmt.Errorf("The loadout has %v twice", id)
+		}
+	}
+	return nil
+	// >>>
+}
+
+// the charges needed to cast every spell of a loadout, by slot
+func loadout_charges(rules Ruleset, loadout [LOADOUT_SIZE]SpellID) [LOADOUT_SIZE]int {
+	// <<<
+	charges := [LOADOUT_SIZE]int{}
+	for i, id := range loadout {
+		charges[i] = rules.Charges[slices.Index(SPELLS, id)]
+	}
+	return charges
+	// >>>
+}
+
+// the pool of a ruleset in the order of SPELLS
+func spell_infos(rules Ruleset) []SpellInfo {
+	// <<<
+	result := []SpellInfo{}
+	for i, id := range SPELLS {
+		info := SPELL_REGISTRY[id].info()
+		info.Charges = rules.Charges[i]
+		info.Damage = rules.SpellDamage[i]
+		result = append(result, info)
+	}
+	return result
+	// >>>
+}
+
+func apply_spell(gw *GameWrapper, player_index int, action Action) error {
+	// <<<
+	spell, ok := SPELL_REGISTRY[action.Spell]
+	if !ok {
+		return fmt.Errorf("Invalid Spell")
+	}
+	slot := slices.Index(gw.Game.Loadouts[player_index][:], action.Spell)
+	if slot < 0 {
+		return fmt.Errorf("The spell is not in your loadout")
+	}
+	if gw.Game.Players[player_index][slot] < loadout_charges(gw.Rules, gw.Game.Loadouts[player_index])[slot] {
+		return fmt.Errorf("The spell is not charged yet")
+	}
+	if err := spell.check(*gw, player_index, action); err != nil {
+		return err
+	}
+	gw.Game.Players[player_index][slot] = 0
+	spell.apply(gw, player_index, action)
+	return nil
+	// >>>
+}
+
+// the half of the board the target may be in, the middle row belongs to the
+// lower half of seat 0 like in owner
+func check_target(gw GameWrapper, target SpellTarget, to Pos) error {
+	// <<<
+	if target == TARGET_NONE {
+		return nil
+	}
+	board := gw.Game.Board
+	if !valid(board, to.Row, to.Col) {
+		return fmt.Errorf("Invalid Cell")
+	}
+	enemy := gw.Game.ActivePlayer == (sign(to.Row-len(board)/2)+1)/2
+	switch target {
+	case TARGET_ENEMY:
+		if !enemy {
+			return fmt.Errorf("Invalid Cell")
+		}
+	case TARGET_OWN, TARGET_OWN_PAIR:
+		if enemy || board[to.Row][to.Col].Type != ELEMENTAL {
+			return fmt.Errorf("Invalid Cell")
+		}
+	case TARGET_OWN_EMPTY:
+		if enemy || board[to.Row][to.Col].Type != EMPTY {
+			return fmt.Errorf("Invalid Cell")
+		}
+	}
+	return nil
+	// >>>
+}
+
+// the cells hit by area around to, rows are kept within the enemy half of
+// the active player
+func area_cells(game Game, area AreaShape, to Pos) []Pos {
+	// <<<
+	size := len(game.Board)
+	low, high := 0, size/2-1
+	if game.ActivePlayer == 1 {
+		low, high = size/2, size-1
+	}
+
+	result := []Pos{}
+	switch area {
+	case AREA_CELL:
+		result = append(result, to)
+	case AREA_CROSS:
+		for i := low; i <= high; i++ {
+			result = append(result, Pos{i, to.Col})
+		}
+		for j := 0; j < size; j++ {
+			if j != to.Col {
+				result = append(result, Pos{to.Row, j})
+			}
+		}
+	case AREA_SQUARE:
+		for i := clamp(to.Row-1, low, high); i <= clamp(to.Row+1, low, high); i++ {
+			for j := max(to.Col-1, 0); j <= min(to.Col+1, size-1); j++ {
+				result = append(result, Pos{i, j})
+			}
+		}
+	}
+	return result
+	// >>>
+}
+
+// =============================================================================
+
+// damages every cell of its area by its damage in the ruleset
+type damage_spell struct{ SpellInfo }
+
+func (s damage_spell) info() SpellInfo { return s.SpellInfo }
+
+func (s damage_spell) check(gw GameWrapper, player_index int, action Action) error {
+	return check_target(gw, s.Target, action.To)
+}
+
+func (s damage_spell) apply(gw *GameWrapper, player_index int, action Action) {
+	// <<<
+	damage := gw.Rules.SpellDamage[slices.Index(SPELLS, s.Spell)]
+	for _, p := range area_cells(gw.Game, s.Area, action.To) {
+		apply_damage(gw.Rules, &gw.Game, p.Row, p.Col, damage)
+	}
+	// >>>
+}
+
+// restores the full health of the level
+type heal_spell struct{ SpellInfo }
+
+func (s heal_spell) info() SpellInfo { return s.SpellInfo }
+
+func (s heal_spell) check(gw GameWrapper, player_index int, action Action) error {
+	return check_target(gw, s.Target, action.To)
+}
+
+func (s heal_spell) apply(gw *GameWrapper, player_index int, action Action) {
+	cell := &gw.Game.Board[action.To.Row][action.To.Col]
+	cell.Health = gw.Rules.Health[cell.Level-1]
+}
+
+// the turn does not advance after the next action
+type double_turn struct{ SpellInfo }
+
+func (s double_turn) info() SpellInfo { return s.SpellInfo }
+
+func (s double_turn) check(gw GameWrapper, player_index int, action Action) error { return nil }
+
+func (s double_turn) apply(gw *GameWrapper, player_index int, action Action) {
+	gw.SkipAdvance = 1
+}
+
+// puts an effect on the elemental for a number of turns of its owner
+type effect_spell struct {
+	SpellInfo
+	effect Effect
+	turns  int
+}
+
+func (s effect_spell) info() SpellInfo { return s.SpellInfo }
+
+func (s effect_spell) check(gw GameWrapper, player_index int, action Action) error {
+	// <<<
+	if err := check_target(gw, s.Target, action.To); err != nil {
+		return err
+	}
+	if gw.Game.Board[action.To.Row][action.To.Col].Type != ELEMENTAL {
+		return fmt.Errorf("Invalid Cell")
+	}
+	return nil
+	// >>>
+}
+
+func (s effect_spell) apply(gw *GameWrapper, player_index int, action Action) {
+	apply_effect(gw.Game.Board, action.To, s.effect, s.turns)
+}
+
+// exchanges the cells of two own elementals
+type swap_spell struct{ SpellInfo }
+
+func (s swap_spell) info() SpellInfo { return s.SpellInfo }
+
+func (s swap_spell) check(gw GameWrapper, player_index int, action Action) error {
+	// <<<
+	if err := check_target(gw, s.Target, action.From); err != nil {
+		return err
+	}
+	if err := check_target(gw, s.Target, action.To); err != nil {
+		return err
+	}
+	if action.From == action.To {
+		return fmt.Errorf("Invalid Cell")
+	}
+	return nil
+	// >>>
+}
+
+func (s swap_spell) apply(gw *GameWrapper, player_index int, action Action) {
+	// <<<
+	from, to := action.From, action.To
+	gw.Game.Board[to.Row][to.Col], gw.Game.Board[from.Row][from.Col] =
+		gw.Game.Board[from.Row][from.Col], gw.Game.Board[to.Row][to.Col]
+	// >>>
+}
+
+// a level 1 elemental of the element of the first own neighbour, up, down,
+// left or right of the empty cell
+type summon_spell struct{ SpellInfo }
+
+func (s summon_spell) info() SpellInfo { return s.SpellInfo }
+
+func summon_element(board Board, to Pos) Element {
+	// <<<
+	for _, d := range [4]Pos{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+		next := Pos{to.Row + d.Row, to.Col + d.Col}
+		if valid(board, next.Row, next.Col) && owner(board, next.Row) == owner(board, to.Row) &&
+			board[next.Row][next.Col].Type == ELEMENTAL {
+			return board[next.Row][next.Col].Element
+		}
+	}
+	return ""
+	// >>>
+}
+
+func (s summon_spell) check(gw GameWrapper, player_index int, action Action) error {
+	// <<<
+	if err := check_target(gw, s.Target, action.To); err != nil {
+		return err
+	}
+	if summon_element(gw.Game.Board, action.To) == "" {
+		return fmt.Errorf("A summon needs an own elemental next to it")
+	}
+	return nil
+	// >>>
+}
+
+func (s summon_spell) apply(gw *GameWrapper, player_index int, action Action) {
+	place_elemental(gw.Rules, gw.Game.Board, action.To, summon_element(gw.Game.Board, action.To))
+}
@@ -15,9 +15,6 @@ const ELEMENTS = [
     ELEMENT.NATURE,
     ELEMENT.ENERGY,
 ]
-const HEALTH = [1, 2, 6]
-const DAMAGE = [1, 2, 4]
-const REACH = [3, 5, 7]
 const COLORS = {}
 COLORS[ELEMENT.AIR]    /**/ = [{ r: 0x06, g: 0xb6, b: 0xd4 }, { r: 0x7d, g: 0xd3, b: 0xfc }] // cyan-500      sky-300
 COLORS[ELEMENT.ROCK]   /**/ = [{ r: 0x71, g: 0x71, b: 0x7a }, { r: 0x3f, g: 0x3f, b: 0x46 }] // zinc-500      zinc-700
@@ -25,19 +22,17 @@ COLORS[ELEMENT.FIRE]   /**/ = [{ r: 0xfb, g: 0x92, b: 0x3c }, { r: 0xdc, g: 0x26
 COLORS[ELEMENT.WATER]  /**/ = [{ r: 0x0e, g: 0xa5, b: 0xe9 }, { r: 0x25, g: 0x63, b: 0xeb }] // sky-500       blue-600
 COLORS[ELEMENT.NATURE] /**/ = [{ r: 0x22, g: 0xc5, b: 0x5e }, { r: 0x0d, g: 0x94, b: 0x88 }] // green-500     teal-600
 COLORS[ELEMENT.ENERGY] /**/ = [{ r: 0xd9, g: 0x46, b: 0xef }, { r: 0x6d, g: 0x28, b: 0xd9 }] // fuchsia-500   violet-700
-const SPELL = { FS: 'fs', HV: 'hv', AF: 'af', DT: 'dt', MS: 'ms' };
-const SPELLS = [SPELL.FS, SPELL.HV, SPELL.AF, SPELL.DT, SPELL.MS];
-const CHARGES = {}
-CHARGES[SPELL.FS] = 4;
-CHARGES[SPELL.HV] = 5;
-CHARGES[SPELL.AF] = 7;
-CHARGES[SPELL.DT] = 9;
-CHARGES[SPELL.MS] = 10;
+const TARGET = { NONE: 'none', OWN: 'own', OWN_PAIR: 'own_pair', OWN_EMPTY: 'own_empty', ENEMY: 'enemy' };
+const AREA = { NONE: 'none', CELL: 'cell', CROSS: 'cross', SQUARE: 'square' };
 const CELLTYPE = {
     ELEMENTAL: 'elemental',
     BLOCK: 'block',
     EMPTY: 'empty',
 };
+const LOADOUT_SIZE = 5; // spells per player
+const EFFECT = { BURN: 0, STUN: 1, SHIELD: 2, HASTE: 3 }; // indices of cell.status
+const HASTE_REACH = 2;
+const CASCADE_STEP_TIME = 600; // ms per step of a merge cascade
 const ACTION = {
     SKIP: 'skip',
     SPELL: 'spell',
@@ -46,13 +41,34 @@ const ACTION = {
 };
 // >>>
 
-const SIZE = 12;
+let SIZE = 12; // of the board of the current game
+// the rules of the current game come from the server, see update_rules
+let RULES = null;
+let HEALTH = [];
+let DAMAGE = [];
+let REACH = [];
+let LEVEL_AREAS = []; // of attacks by level
+let TRAITS = {}; // by element, see traits.go
+let ADVANTAGE = {}; // added damage by attacker and target element
+let SPELLS = []; // the loadout of the player
+let NAMES = {};
+let CHARGES = {};
+let TARGETS = {};
+let AREAS = {};
 const PI = Math.PI;
 let CELL_SIZE = 1;
 let GAME = null;
 let LOBBY_ID = null;
 let PLAYER_ID = null;
 let PLAYER_INDEX = 0;
+let HOTSEAT = false;
+let PLACEMENT = null;
+let PLACEMENT_ELEMENT = '';
+let LOADOUT = null;
+let LOADOUT_PICKS = [];
+let CASCADE = null; // the merge cascade being animated
+let CASCADE_SEEN = ''; // lobby and action of the last animated cascade
+let CASCADE_START = 0;
 let POINTER = { x: -1000, y: -1000 };
 let SELECTED_ELEMENTAL = { row: -1, col: -1 };
 let SELECTED_CELL = { row: -1, col: -1 };
@@ -74,7 +90,7 @@ function lerp(a, b, t) {
 }
 
 function valid(pos) {
-    return pos.row >= 0 && pos.row < 12 && pos.col >= 0 && pos.col < 12;
+    return pos.row >= 0 && pos.row < SIZE && pos.col >= 0 && pos.col < SIZE;
 }
 
 function correct_player(pos) {
@@ -119,18 +135,110 @@ function equal(pos1, pos2) {
     return pos1.col === pos2.col && pos1.row === pos2.row;
 }
 
+// takes the rules of a game from a lobby response or /api/rules, the spell
+// buttons follow the loadout of the game
+function update_rules(rules) {
+    // <<<
+    RULES = rules;
+    HEALTH = rules.health;
+    DAMAGE = rules.damage;
+    REACH = rules.reach;
+    LEVEL_AREAS = rules.areas;
+    TRAITS = rules.traits ?? {};
+    ADVANTAGE = rules.advantage ?? {};
+    NAMES = {};
+    CHARGES = {};
+    TARGETS = {};
+    AREAS = {};
+    rules.spells.forEach(s => {
+        NAMES[s.spell] = s.name;
+        CHARGES[s.spell] = s.charges;
+        TARGETS[s.spell] = s.target;
+        AREAS[s.spell] = s.area;
+    });
+    SPELLS = [];
+
+    const buttons = document.querySelector('#loadout_spells');
+    buttons.replaceChildren();
+    rules.spells.forEach(s => {
+        const button = document.createElement('button');
+        button.textContent = s.name;
+        button.dataset.spell = s.spell;
+        button.addEventListener('click', (_) => {
+            if (LOADOUT_PICKS.includes(s.spell)) {
+                LOADOUT_PICKS = LOADOUT_PICKS.filter(p => p !== s.spell);
+            } else if (LOADOUT_PICKS.length < LOADOUT_SIZE) {
+                LOADOUT_PICKS.push(s.spell);
+            }
+            update_loadout(LOADOUT);
+        });
+        buttons.appendChild(button);
+    });
+    // >>>
+}
+
+// one button per spell of the loadout
+function update_spells(loadout) {
+    // <<<
+    SPELLS = [...loadout];
+    const spells = document.querySelector('#spells');
+    spells.replaceChildren();
+    SPELLS.forEach(spell => {
+        const button = document.createElement('button');
+        button.id = spell;
+        button.dataset.spell = spell;
+        button.textContent = NAMES[spell];
+        button.appendChild(document.createElement('p'));
+        button.addEventListener('click', (_) => {
+            if (!CAN_USE_SPELL) return
+            spells.querySelectorAll('button').forEach(e => e.classList.remove('highlight'));
+            SELECTED_SPELL = spell;
+            button.classList.add('highlight');
+        });
+        spells.appendChild(button);
+    });
+    // >>>
+}
+
+function update_loadout(loadout) {
+    // <<<
+    LOADOUT = loadout;
+    document.querySelector('#loadout_panel').hidden = loadout == null;
+    if (loadout == null) {
+        LOADOUT_PICKS = [];
+        return
+    }
+    const seat = HOTSEAT ? loadout.ready.indexOf(false) : PLAYER_INDEX;
+    document.querySelectorAll('#loadout_spells > button').forEach(button => {
+        button.classList.toggle('highlight', LOADOUT_PICKS.includes(button.dataset.spell));
+        button.disabled = loadout.ready[seat];
+    });
+    document.querySelector('#loadout_status').textContent = loadout.ready[seat] ?
+        'Loadout: waiting for the opponent' : `Loadout: pick ${LOADOUT_SIZE} spells, ${LOADOUT_SIZE - LOADOUT_PICKS.length} left`;
+    document.querySelector('#loadout_ready').disabled = loadout.ready[seat] || LOADOUT_PICKS.length !== LOADOUT_SIZE;
+    // >>>
+}
+
 function update_game(game) {
     // <<<
+    if (HOTSEAT) {
+        PLAYER_INDEX = game.active_player;
+    }
     if (GAME != null && GAME.active_player != game.active_player) {
         CAN_USE_SPELL = true;
         Array.from(document.querySelectorAll("#spells > *")).map(e => e.style['filter'] = '')
         SELECTED_ELEMENTAL = { row: -1, col: -1 };
         SELECTED_SPELL = '';
     }
+    const loadout = game.loadouts[PLAYER_INDEX];
+    if (loadout.join() !== SPELLS.join()) {
+        update_spells(loadout);
+    }
     SPELLS.map((s, i) => {
         document.querySelector(`#${s}>p`).textContent = `${game.players[PLAYER_INDEX][i] ?? 0}/${CHARGES[s]}`;
     })
     GAME = game
+    SIZE = game.board.length
     if (PLAYER_INDEX === 1) {
         GAME.board = GAME.board.toReversed();
     }
@@ -139,24 +247,28 @@ function update_game(game) {
 
 function aos2soa(aos) {
     // <<<
+    const size = aos.board.length
     let soa = {
         players: aos.players,
+        loadouts: aos.loadouts,
         active_player: aos.active_player,
         turn: aos.turn,
         board_soa: {
-            type: new Array(SIZE).fill().map(() => new Array(SIZE).fill()),
-            element: new Array(SIZE).fill().map(() => new Array(SIZE).fill()),
-            health: new Array(SIZE).fill().map(() => new Array(SIZE).fill()),
-            level: new Array(SIZE).fill().map(() => new Array(SIZE).fill()),
+            type: new Array(size).fill().map(() => new Array(size).fill()),
+            element: new Array(size).fill().map(() => new Array(size).fill()),
+            health: new Array(size).fill().map(() => new Array(size).fill()),
+            level: new Array(size).fill().map(() => new Array(size).fill()),
+            status: new Array(size).fill().map(() => new Array(size).fill()),
         }
     }
 
-    for (let i = 0; i < SIZE; i++) {
-        for (let j = 0; j < SIZE; j++) {
+    for (let i = 0; i < size; i++) {
+        for (let j = 0; j < size; j++) {
             soa.board_soa.type[i][j] = aos.board[i][j].type
             soa.board_soa.element[i][j] = aos.board[i][j].element
             soa.board_soa.health[i][j] = aos.board[i][j].health
             soa.board_soa.level[i][j] = aos.board[i][j].level
+            soa.board_soa.status[i][j] = aos.board[i][j].status
         }
     }
 
@@ -165,20 +277,23 @@ function aos2soa(aos) {
 }
 function soa2aos(soa) {
     // <<<
+    const size = soa.board_soa.type.length
     let aos = {
         players: soa.players,
+        loadouts: soa.loadouts,
         active_player: soa.active_player,
         turn: soa.turn,
-        board: new Array(SIZE).fill(null).map(() => new Array(SIZE).fill(null)),
+        board: new Array(size).fill(null).map(() => new Array(size).fill(null)),
     }
 
-    for (let i = 0; i < SIZE; i++) {
-        for (let j = 0; j < SIZE; j++) {
+    for (let i = 0; i < size; i++) {
+        for (let j = 0; j < size; j++) {
             aos.board[i][j] = {
                 type: soa.board_soa.type[i][j],
                 element: soa.board_soa.element[i][j],
                 health: soa.board_soa.health[i][j],
                 level: soa.board_soa.level[i][j],
+                status: soa.board_soa.status?.[i][j] ?? [0, 0, 0, 0],
             }
         }
     }
@@ -233,6 +348,111 @@ function get_path(grid, start, target) {
     // >>>
 }
 
+function update_draft(draft) {
+    // <<<
+    document.querySelector('#draft_panel').hidden = draft == null;
+    if (draft == null) return
+    const taken = [...draft.bans, ...draft.picks[0], ...draft.picks[1]];
+    const yours = HOTSEAT || draft.seat === PLAYER_INDEX;
+    const verb = draft.ban ? 'ban' : 'pick';
+    document.querySelector('#draft_status').textContent = yours ?
+        `Draft: ${verb} an element` : `Draft: the opponent is choosing an element to ${verb}`;
+    document.querySelectorAll('#draft_elements > button').forEach(button => {
+        button.disabled = !yours || taken.includes(button.dataset.element);
+    });
+    // >>>
+}
+
+function update_placement(placement) {
+    // <<<
+    PLACEMENT = placement;
+    document.querySelector('#placement_panel').hidden = placement == null;
+    if (placement == null) return
+    const seat = HOTSEAT ? placement.ready.indexOf(false) : PLAYER_INDEX;
+    const elements = placement.elements[seat] ?? [];
+    if (!elements.includes(PLACEMENT_ELEMENT)) {
+        PLACEMENT_ELEMENT = elements[0] ?? '';
+    }
+    const buttons = document.querySelector('#placement_elements');
+    if (buttons.dataset.elements !== elements.join()) {
+        buttons.dataset.elements = elements.join();
+        buttons.replaceChildren(...elements.map(element => {
+            const button = document.createElement('button');
+            const [a, b] = COLORS[element];
+            button.textContent = element;
+            button.dataset.element = element;
+            button.style.background = `linear-gradient(rgb(${a.r},${a.g},${a.b}), rgb(${b.r},${b.g},${b.b}))`;
+            button.addEventListener('click', (_) => {
+                PLACEMENT_ELEMENT = element;
+                update_placement(PLACEMENT);
+            });
+            return button;
+        }));
+    }
+    buttons.querySelectorAll('button').forEach(button => {
+        button.classList.toggle('highlight', button.dataset.element === PLACEMENT_ELEMENT);
+    });
+    let text = `Place your elementals, ${placement.budget[seat]} left.`;
+    if (!placement.deadline.startsWith('0001')) {
+        const seconds = Math.max(0, Math.round((new Date(placement.deadline) - Date.now()) / 1000));
+        text += ` ${seconds}s remaining.`;
+    }
+    if (placement.ready[seat]) {
+        text = 'Waiting for the opponent to get ready.';
+    }
+    document.querySelector('#placement_status').textContent = text;
+    // >>>
+}
+
+async function handle_place(kind, pos) {
+    // <<<
+    const request = { lobby_id: LOBBY_ID, player_id: PLAYER_ID, kind, element: PLACEMENT_ELEMENT, pos: { row: -1, col: -1 } };
+    if (pos != null) {
+        request.pos = { row: PLAYER_INDEX === 1 ? SIZE - 1 - pos.row : pos.row, col: pos.col };
+    }
+    const data = await fetch_post('/api/place', request);
+    console.log('Response:', data);
+    if (!data.ok) return
+    update_game(soa2aos(data.result.game_soa));
+    update_placement(data.result.placement);
+    // >>>
+}
+
+function update_series(series, result) {
+    // <<<
+    let text = '';
+    if (series.best_of > 1) {
+        text = `Game ${series.number} of best of ${series.best_of}, you ${series.score[PLAYER_INDEX]} - ${series.score[1 - PLAYER_INDEX]} opponent. `;
+    }
+    if (result !== '*') {
+        text += `Game over: ${result}`;
+    }
+    document.querySelector('#error-response').textContent = text;
+    document.querySelector('#rematch').hidden = result === '*';
+    // >>>
+}
+
+// moves on to the rematch or the next game of the series
+async function follow(lobby_id) {
+    // <<<
+    const data = await fetch_post('/api/join', { lobby_id, player_id: PLAYER_ID })
+    console.log('Response:', data);
+    if (!data.ok || !data.result.ok) return
+    LOBBY_ID = lobby_id;
+    HOTSEAT = data.result.hotseat;
+    PLAYER_INDEX = data.result.player_index;
+    update_rules(data.result.rules);
+    update_game(soa2aos(data.result.game_soa));
+    document.getElementById('lobby_code').value = LOBBY_ID;
+    remember_seat(data.result.reconnect_token);
+    // >>>
+}
+
+function remember_seat(token) {
+    if (!token) return
+    history.replaceState(null, '', `#lobby=${LOBBY_ID}&token=${token}`);
+}
+
 async function fetch_get(path) {
     // <<<
     try {
@@ -277,13 +497,35 @@ async function fetch_post(path, data) {
     // >>>
 }
 
+// the strength of the trait of the element of cell, 0 when it has another one
+function trait_strength(cell, trait) {
+    // <<<
+    const t = TRAITS[cell.element]
+    return (t != null && t.trait === trait) ? t.strength : 0
+    // >>>
+}
+
+// the damage of an attack after the armor of the target, halved for the
+// other cells of the area of the attack
+function attack_damage(from, to, area = false) {
+    // <<<
+    let damage = Math.max(DAMAGE[from.level - 1] + (ADVANTAGE[from.element]?.[to.element] ?? 0), 0)
+    if (area) { damage = Math.floor(damage / 2) }
+    const armor = trait_strength(to, 'armor')
+    return (damage > 0 && armor > 0) ? Math.max(damage - armor, 1) : damage
+    // >>>
+}
+
 function get_attack_range(pos) {
     // <<<
     const cell = GAME.board[pos.row][pos.col]
 
-    const r = REACH[cell.level - 1] ?? 0
-    let col_a = clamp(pos.col - 1, 0, SIZE - 1)
-    let col_b = clamp(pos.col + 1, 0, SIZE - 1)
+    let r = REACH[cell.level - 1] ?? 0
+    if (cell.status?.[EFFECT.HASTE] > 0) { r += HASTE_REACH }
+    r += trait_strength(cell, 'reach')
+    const c = 1 + trait_strength(cell, 'wide')
+    let col_a = clamp(pos.col - c, 0, SIZE - 1)
+    let col_b = clamp(pos.col + c, 0, SIZE - 1)
     let row_a = clamp(pos.row + 1, SIZE / 2 + 1, SIZE - 1)
     let row_b = clamp(pos.row + r, SIZE / 2 + 1, SIZE - 1)
     if (pos.row >= SIZE / 2) {
@@ -300,6 +542,7 @@ function able_to_attack(pos) {
     const cell = get_cell(pos)
     if (cell == null) return false;
     if (cell.type !== CELLTYPE.ELEMENTAL) return false;
+    if (cell.status?.[EFFECT.STUN] > 0) return false;
 
     const range = get_attack_range(pos)
 
@@ -323,8 +566,9 @@ function can_attack(from, to) {
     const cell2 = get_cell(to)
     if (cell2 == null || cell2.type !== CELLTYPE.ELEMENTAL) return false;
 
-    const reach = REACH[cell1.level - 1]
-    if (Math.abs(from.row - to.row) > reach || Math.abs(from.col - to.col) > 1) {
+    let reach = REACH[cell1.level - 1] + trait_strength(cell1, 'reach')
+    if (cell1.status?.[EFFECT.HASTE] > 0) { reach += HASTE_REACH }
+    if (Math.abs(from.row - to.row) > reach || Math.abs(from.col - to.col) > 1 + trait_strength(cell1, 'wide')) {
         return false
     }
 
@@ -560,6 +804,89 @@ function render_elemental({ ctx, x, y, radius, reverse, preview, level, element,
     // >>>
 }
 
+// shields as a ring, stuns as a pale cover, burns as a red tint and haste as
+// a mark in the corner
+function render_status(ctx, x, y, cell) {
+    // <<<
+    const status = cell.status ?? [0, 0, 0, 0]
+    if (status[EFFECT.SHIELD] > 0) {
+        ctx.beginPath();
+        ctx.arc(x + CELL_SIZE / 2, y + CELL_SIZE / 2, CELL_SIZE / 2 - 2, 0, 2 * PI);
+        ctx.strokeStyle = '#e2e8f0';
+        ctx.lineWidth = 1 + status[EFFECT.SHIELD];
+        ctx.stroke();
+    }
+    if (status[EFFECT.BURN] > 0) {
+        ctx.fillStyle = 'hsl(0, 80%, 50%, 0.3)';
+        ctx.fillRect(x, y, CELL_SIZE, CELL_SIZE);
+    }
+    if (status[EFFECT.STUN] > 0) {
+        ctx.fillStyle = 'hsl(200, 90%, 90%, 0.45)';
+        ctx.fillRect(x, y, CELL_SIZE, CELL_SIZE);
+    }
+    if (status[EFFECT.HASTE] > 0) {
+        ctx.beginPath();
+        ctx.moveTo(x + CELL_SIZE - 2, y + 2);
+        ctx.lineTo(x + CELL_SIZE - 2, y + CELL_SIZE / 4);
+        ctx.lineTo(x + CELL_SIZE - CELL_SIZE / 4, y + 2);
+        ctx.fillStyle = '#facc15';
+        ctx.fill();
+    }
+    // >>>
+}
+
+// animates every new cascade once, step by step
+function update_cascade(cascade) {
+    // <<<
+    if (cascade == null || cascade.steps == null || cascade.steps.length === 0) { return }
+    const key = `${LOBBY_ID}:${cascade.action}`
+    if (key === CASCADE_SEEN) { return }
+    CASCADE_SEEN = key
+    CASCADE = cascade
+    CASCADE_START = performance.now()
+    // >>>
+}
+
+// ascended cells glow, removed ones are crossed out and the combo is shown
+// in the middle of the board
+function render_cascade(ctx) {
+    // <<<
+    if (CASCADE === null) { return }
+    const i = Math.floor((performance.now() - CASCADE_START) / CASCADE_STEP_TIME)
+    if (i >= CASCADE.steps.length) {
+        CASCADE = null
+        return
+    }
+    const step = CASCADE.steps[i]
+    const row = (p) => PLAYER_INDEX === 1 ? SIZE - 1 - p.row : p.row
+
+    ctx.lineWidth = 3;
+    step.ascended.forEach(p => {
+        ctx.beginPath();
+        ctx.arc((p.col + 0.5) * CELL_SIZE, (row(p) + 0.5) * CELL_SIZE, CELL_SIZE / 2 - 1, 0, 2 * PI);
+        ctx.strokeStyle = '#facc15';
+        ctx.stroke();
+    })
+    step.removed.forEach(p => {
+        const x = p.col * CELL_SIZE, y = row(p) * CELL_SIZE
+        ctx.beginPath();
+        ctx.moveTo(x + 4, y + 4);
+        ctx.lineTo(x + CELL_SIZE - 4, y + CELL_SIZE - 4);
+        ctx.moveTo(x + CELL_SIZE - 4, y + 4);
+        ctx.lineTo(x + 4, y + CELL_SIZE - 4);
+        ctx.strokeStyle = 'hsl(0, 0%, 100%, 0.6)';
+        ctx.stroke();
+    })
+
+    const text = step.combo > 1 ? `combo x${step.combo} +${step.charges}` : `+${step.charges}`
+    ctx.font = `bold ${Math.round(CELL_SIZE * 0.6)}px sans-serif`;
+    ctx.textAlign = 'center';
+    ctx.textBaseline = 'middle';
+    ctx.fillStyle = '#facc15';
+    ctx.fillText(text, SIZE * CELL_SIZE / 2, SIZE * CELL_SIZE / 2);
+    // >>>
+}
+
 function render_attack(ctx, pos) {
     // <<<
     if (!valid(pos) || get_cell(pos).type !== CELLTYPE.ELEMENTAL) { return }
@@ -577,6 +904,58 @@ function render_attack(ctx, pos) {
     // >>>
 }
 
+// the damage pos would take from the attack, 0 when it is not hit
+function attacked_damage(attacked, pos) {
+    // <<<
+    if (attacked == null) { return 0 }
+    const from = get_cell(attacked.from)
+    if (equal(pos, attacked.to)) { return attack_damage(from, get_cell(pos)) }
+    if (!get_area(LEVEL_AREAS[from.level - 1], attacked.to).some(p => equal(p, pos))) { return 0 }
+    return attack_damage(from, get_cell(pos), true)
+    // >>>
+}
+
+// the cells a spell hits around pos, the enemy half is always on top here
+function get_spell_area(spell, pos) {
+    return get_area(AREAS[spell], pos);
+}
+
+// the cells of an area around pos within the enemy half
+function get_area(area, pos) {
+    // <<<
+    const cells = [];
+    switch (area) {
+        case AREA.CELL:
+            cells.push(pos);
+            break;
+        case AREA.CROSS:
+            for (let row = 0; row < SIZE / 2; row++) cells.push({ row, col: pos.col });
+            for (let col = 0; col < SIZE; col++) if (col !== pos.col) cells.push({ row: pos.row, col });
+            break;
+        case AREA.SQUARE:
+            for (let row = Math.max(pos.row - 1, 0); row <= Math.min(pos.row + 1, SIZE / 2 - 1); row++)
+                for (let col = Math.max(pos.col - 1, 0); col <= Math.min(pos.col + 1, SIZE - 1); col++)
+                    cells.push({ row, col });
+            break;
+    }
+    return cells;
+    // >>>
+}
+
+function render_spell_area(ctx, spell, pos) {
+    // <<<
+    if (!valid(pos) || TARGETS[spell] !== TARGET.ENEMY || pos.row >= SIZE / 2) { return }
+
+    ctx.fillStyle = 'hsl(30, 90%, 60%, 0.3)';
+    ctx.strokeStyle = 'hsl(30, 90%, 60%, 0.6)';
+    ctx.lineWidth = 2;
+    get_spell_area(spell, pos).forEach(({ row, col }) => {
+        ctx.fillRect(col * CELL_SIZE, row * CELL_SIZE, CELL_SIZE, CELL_SIZE);
+        ctx.strokeRect(col * CELL_SIZE, row * CELL_SIZE, CELL_SIZE, CELL_SIZE);
+    });
+    // >>>
+}
+
 function render_blank() {
     // <<<
     const canvas = document.getElementById('cnv');
@@ -708,9 +1087,10 @@ function render() {
                     radius: CELL_SIZE / 2,
                     reverse: row < SIZE / 2,
                     preview: false, //(PLAYER_INDEX === 1) !== (GAME.active_player === Math.floor(row / (BOARD_SIZE / 2))),
-                    damage: (attacked != null && equal({ row, col }, attacked.to)) ? DAMAGE[get_cell(attacked.from).level - 1] : 0,
+                    damage: attacked_damage(attacked, { row, col }),
                     ...cell,
                 })
+                render_status(ctx, x, y, cell)
             }
         }
     }
@@ -730,6 +1110,10 @@ function render() {
         able_to_attack(SELECTED_ELEMENTAL)) {
         render_attack(ctx, SELECTED_ELEMENTAL);
     }
+    if (SELECTED_SPELL !== '') {
+        render_spell_area(ctx, SELECTED_SPELL, HOVERED_CELL);
+    }
+    render_cascade(ctx);
 
     // >>>
 }
@@ -762,11 +1146,19 @@ async function loop(time_now) {
             fetch("/api/read", {
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({ lobby_id: LOBBY_ID }),
+                body: JSON.stringify({ lobby_id: LOBBY_ID, player_id: PLAYER_ID }),
             }).then(response => response.json())
                 .then(data => {
                     if (data.ok) {
                         update_game(soa2aos(data.game_soa));
+                        update_series(data.series, data.result);
+                        update_draft(data.draft);
+                        update_placement(data.placement);
+                        update_loadout(data.loadout);
+                        update_cascade(data.cascade);
+                        if (data.result !== '*' && data.next) {
+                            follow(data.next);
+                        }
                     } else {
                         clock -= 1000
                     }
@@ -818,6 +1210,7 @@ async function handle_move() {
         return false
     }
     update_game(soa2aos(data.result.game_soa));
+    update_cascade(data.result.cascade);
     return true
     // >>>
 }
@@ -851,6 +1244,7 @@ async function handle_attack() {
         return false
     }
     update_game(soa2aos(data.result.game_soa));
+    update_cascade(data.result.cascade);
     return true
     // >>>
 }
@@ -867,7 +1261,7 @@ async function handle_spell() {
             from: { row: -1, col: -1 },
         },
     }
-    if (SELECTED_SPELL !== SPELL.DT) {
+    if (TARGETS[SELECTED_SPELL] !== TARGET.NONE) {
         if (PLAYER_INDEX === 0) {
             request.action.to = {
                 col: SELECTED_CELL.col,
@@ -880,6 +1274,12 @@ async function handle_spell() {
             }
         }
     }
+    if (TARGETS[SELECTED_SPELL] === TARGET.OWN_PAIR) { // the selected elemental swaps with the cell
+        request.action.from = {
+            col: SELECTED_ELEMENTAL.col,
+            row: PLAYER_INDEX === 0 ? SELECTED_ELEMENTAL.row : SIZE - 1 - SELECTED_ELEMENTAL.row,
+        }
+    }
     const data = await fetch_post('/api/action', request);
     console.log("response:", data)
     if (!data.ok || !data.result.ok) {
@@ -887,6 +1287,7 @@ async function handle_spell() {
         return false
     }
     update_game(soa2aos(data.result.game_soa));
+    update_cascade(data.result.cascade);
 
     document.querySelector(`#${SELECTED_SPELL}>p`).textContent = `${GAME.players[PLAYER_INDEX][SPELLS.indexOf(SELECTED_SPELL)] ?? 0}/${CHARGES[SELECTED_SPELL]}`;
     SELECTED_SPELL = ''
@@ -905,13 +1306,19 @@ document.addEventListener('DOMContentLoaded', async () => {
     const canvas = document.querySelector('#cnv');
     const cancel = document.querySelector('#cancel');
     const skip = document.querySelector('#skip');
+    const rematch = document.querySelector('#rematch');
     const confirm = document.querySelector('#confirm');
-    const fs = document.querySelector('#fs');
-    const hv = document.querySelector('#hv');
-    const af = document.querySelector('#af');
-    const dt = document.querySelector('#dt');
-    const ms = document.querySelector('#ms');
-    const spell_elements = [fs, hv, af, dt, ms];
+
+    const presets = await fetch_get('/api/rules');
+    if (presets.ok) {
+        presets.result.forEach(rules => {
+            const option = document.createElement('option');
+            option.value = rules.name;
+            option.textContent = `${rules.name} ${rules.size}x${rules.size}`;
+            document.querySelector('#rules').appendChild(option);
+        });
+        update_rules(presets.result[0]);
+    }
 
     if (sessionStorage.getItem('PLAYER_ID') != null) {
         PLAYER_ID = sessionStorage.getItem('PLAYER_ID');
@@ -925,12 +1332,66 @@ document.addEventListener('DOMContentLoaded', async () => {
 
     new_lobby.addEventListener('click', async (_) => {
         // <<<
-        const data = await fetch_post('/api/new/lobby', { player_id: PLAYER_ID })
+        const options = {
+            hotseat: document.getElementById('hotseat').checked,
+            first: document.getElementById('first').value,
+            side: document.getElementById('side').value,
+            best_of: Number(document.getElementById('best_of').value),
+            draft: document.getElementById('draft').checked,
+            placement: document.getElementById('placement').checked,
+            loadout: document.getElementById('loadout').checked,
+            layout: document.getElementById('placement').checked ? '' : document.getElementById('layout').value,
+            fair: document.getElementById('fair').checked && !document.getElementById('placement').checked,
+            rules: document.getElementById('rules').value,
+        }
+        const data = await fetch_post('/api/new/lobby', { player_id: PLAYER_ID, options })
         console.log('Response:', data);
         if (!data.ok) return
         LOBBY_ID = data.result.lobby_id;
+        HOTSEAT = data.result.hotseat;
+        PLAYER_INDEX = data.result.player_index;
+        update_rules(data.result.rules);
         update_game(soa2aos(data.result.game_soa));
         document.getElementById('lobby_code').value = LOBBY_ID;
+        remember_seat(data.result.reconnect_token);
+        // >>>
+    });
+    ELEMENTS.forEach(element => {
+        // <<<
+        const button = document.createElement('button');
+        const [a, b] = COLORS[element];
+        button.textContent = element;
+        button.dataset.element = element;
+        button.style.background = `linear-gradient(rgb(${a.r},${a.g},${a.b}), rgb(${b.r},${b.g},${b.b}))`;
+        button.addEventListener('click', async (_) => {
+            const data = await fetch_post('/api/draft', { lobby_id: LOBBY_ID, player_id: PLAYER_ID, element })
+            console.log('Response:', data);
+            if (!data.ok) return
+            update_draft(data.result.draft);
+            update_game(soa2aos(data.result.game_soa));
+        });
+        document.querySelector('#draft_elements').appendChild(button);
+        // >>>
+    });
+
+    document.querySelector('#ready').addEventListener('click', (_) => handle_place('ready', null));
+    document.querySelector('#loadout_ready').addEventListener('click', async (_) => {
+        // <<<
+        const data = await fetch_post('/api/loadout', { lobby_id: LOBBY_ID, player_id: PLAYER_ID, spells: LOADOUT_PICKS })
+        console.log('Response:', data);
+        if (!data.ok) return
+        LOADOUT_PICKS = [];
+        update_loadout(data.result.loadout);
+        update_game(soa2aos(data.result.game_soa));
+        // >>>
+    });
+
+    rematch.addEventListener('click', async (_) => {
+        // <<<
+        const data = await fetch_post('/api/rematch', { lobby_id: LOBBY_ID, player_id: PLAYER_ID })
+        console.log('Response:', data);
+        if (!data.ok) return
+        follow(data.result.lobby_id);
         // >>>
     });
     join_lobby.addEventListener('click', async (_) => {
@@ -940,13 +1401,31 @@ document.addEventListener('DOMContentLoaded', async () => {
         console.log('Response:', data);
         if (!data.ok || !data.result.ok) return
         LOBBY_ID = lobby_id;
-        if (data.result.player_index > 0) {
-            PLAYER_INDEX = 1;
-        }
+        HOTSEAT = data.result.hotseat;
+        PLAYER_INDEX = data.result.player_index;
+        update_rules(data.result.rules);
         update_game(soa2aos(data.result.game_soa));
+        remember_seat(data.result.reconnect_token);
         // >>>
     });
 
+    // the hash of a lobby url holds the reconnect token of its seat, opening
+    // the url in another browser sits it on the same seat
+    const hash = new URLSearchParams(location.hash.substring(1));
+    if (hash.get('lobby') != null && hash.get('token') != null) {
+        const lobby_id = hash.get('lobby').toUpperCase();
+        const data = await fetch_post('/api/reconnect', { lobby_id, player_id: PLAYER_ID, reconnect_token: hash.get('token') })
+        console.log('Response:', data);
+        if (data.ok && data.result.ok) {
+            LOBBY_ID = lobby_id;
+            HOTSEAT = data.result.hotseat;
+            PLAYER_INDEX = data.result.player_index;
+            update_rules(data.result.rules);
+            update_game(soa2aos(data.result.game_soa));
+            document.getElementById('lobby_code').value = LOBBY_ID;
+        }
+    }
+
     if (!IS_MOBILE) {
         canvas.addEventListener('pointermove', (event) => {
             // <<<
@@ -968,6 +1447,10 @@ document.addEventListener('DOMContentLoaded', async () => {
         const col = Math.floor(pointerX * SIZE);
         const row = Math.floor(pointerY * SIZE);
         SELECTED_CELL = { row, col };
+        if (PLACEMENT != null && valid(SELECTED_CELL) && GAME != null) {
+            handle_place(get_cell(SELECTED_CELL).type === CELLTYPE.ELEMENTAL ? 'remove' : 'place', SELECTED_CELL);
+            return
+        }
         if (valid(SELECTED_CELL) && GAME != null && correct_player(SELECTED_CELL) &&
             get_cell(SELECTED_CELL).type === CELLTYPE.ELEMENTAL) {
             SELECTED_ELEMENTAL = SELECTED_CELL;
@@ -980,7 +1463,7 @@ document.addEventListener('DOMContentLoaded', async () => {
         SELECTED_CELL = { row: -1, col: -1 }
         SELECTED_ELEMENTAL = { row: -1, col: -1 }
         SELECTED_SPELL = ''
-        spell_elements.forEach(e => {
+        document.querySelectorAll('#spells > *').forEach(e => {
             e.classList.remove('highlight');
         })
         // >>>
@@ -995,11 +1478,12 @@ document.addEventListener('DOMContentLoaded', async () => {
         console.log('Response:', data);
         if (!data.ok || !data.result.ok) return
         update_game(soa2aos(data.result.game_soa));
+        update_cascade(data.result.cascade);
         // >>>
     })
     confirm.addEventListener('click', async (_) => {
         // <<<
-        if (!valid(SELECTED_CELL) && SELECTED_SPELL !== SPELL.DT) return;
+        if (!valid(SELECTED_CELL) && TARGETS[SELECTED_SPELL] !== TARGET.NONE) return;
 
         if (CAN_USE_SPELL && SELECTED_SPELL !== '' && PLAYER_INDEX === GAME.active_player) {
             await handle_spell();
@@ -1029,20 +1513,6 @@ document.addEventListener('DOMContentLoaded', async () => {
         // >>>
     })
 
-    spell_elements.forEach((e, i) => {
-        // <<<
-        e.addEventListener('click', (_) => {
-            if (CAN_USE_SPELL) {
-                spell_elements.forEach(ee => {
-                    ee.classList.remove('highlight');
-                })
-                SELECTED_SPELL = SPELLS[i];
-                e.classList.add('highlight');
-            }
-        })
-        // >>>
-    })
-
     render_blank()
 
     requestAnimationFrame(loop);
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Plain-text line protocol, one command per line, for netcat/telnet and shell
+// bots. Every command is answered with "OK ..." or "ERR ...", BOARD prints
+// the board and ends with a line containing only "END", elementals are shown
+// as element, level, health and their effects like in the position notation.
+// Actions which end in merges answer with the steps of the cascade and the
+// charges they gave. Rows and columns are the server's own, player 0 owns
+// rows size/2..size-1 of a board with size rows.
+//
+//	go run . -tcp :6970
+//	nc localhost 6970
+
+var tcp_addr = flag.String("tcp", "", "also serve the line protocol on this address, e.g. :6970")
+
+const TCP_HELP = `OK commands:
+NEW [HOTSEAT] [DRAFT] [LOADOUT] [CREATOR|OPPONENT|RANDOM] [BOTTOM|TOP] [<ruleset>]
+JOIN <code>
+DRAFT <element>
+LOADOUT <spell> <spell> <spell> <spell> <spell>
+MOVE <row> <col> <row> <col>
+ATTACK <row> <col> <row> <col>
+SPELL <spell> [<row> <col>]
+SPELL <spell> <from row> <from col> <to row> <to col>
+SKIP
+BOARD
+QUIT
+END`
+
+func board_text(rules Ruleset, game Game, player_index int) string {
+	// <<<
+	var sb strings.Builder
+	for i := range game.Board {
+		for j := range game.Board[i] {
+			if j > 0 {
+				sb.WriteByte(' ')
+			}
+			cell := game.Board[i][j]
+			switch cell.Type {
+			case ELEMENTAL:
+				fmt.Fprintf(&sb, "%c%d:%d", strings.ToUpper(string(cell.Element))[0], cell.Level, cell.Health)
+				for e, turns := range cell.Status {
+					if turns > 0 {
+						fmt.Fprintf(&sb, "%c%d", EFFECT_LETTERS[e], turns)
+					}
+				}
+			case BLOCK:
+				sb.WriteString("#")
+			default:
+				sb.WriteString(".")
+			}
+		}
+		sb.WriteByte('\n')
+	}
+	fmt.Fprintf(&sb, "TURN %v ACTIVE %v YOU %v\n", game.Turn, game.ActivePlayer, player_index)
+	for p := 0; p < 2; p++ {
+		fmt.Fprintf(&sb, "CHARGES %v", p)
+		charges := loadout_charges(rules, game.Loadouts[p])
+		for i, s := range game.Loadouts[p] {
+			fmt.Fprintf(&sb, " %v=%v/%v", s, game.Players[p][i], charges[i])
+		}
+		sb.WriteByte('\n')
+	}
+	sb.WriteString("END")
+	return sb.String()
+	// >>>
+}
+
+func parse_positions(fields []string, n int) ([]Pos, error) {
+	// <<<
+	if len(fields) != 2*n {
+		return nil, fmt.Errorf("expected %v numbers", 2*n)
+	}
+	result := make([]Pos, n)
+	for i := 0; i < n; i++ {
+		row, err1 := strconv.Atoi(fields[2*i])
+		col, err2 := strconv.Atoi(fields[2*i+1])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid number")
+		}
+		result[i] = Pos{row, col}
+	}
+	return result, nil
+	// >>>
+}
+
+type tcp_session struct {
+	// <<<
+	player_id string
+	lobby_id  string
+	// >>>
+}
+
+func (s *tcp_session) execute(line string) string {
+	// <<<
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	command := strings.ToUpper(fields[0])
+	if command != "NEW" && command != "JOIN" && command != "HELP" && s.lobby_id == "" {
+		return "ERR not in a lobby"
+	}
+
+	action := Action{}
+	switch command {
+	case "HELP":
+		return TCP_HELP
+	case "NEW":
+		options := LobbyOptions{}
+		for _, field := range fields[1:] {
+			switch word := strings.ToLower(field); word {
+			case "hotseat":
+				options.Hotseat = true
+			case "draft":
+				options.Draft = true
+			case "loadout":
+				options.Loadout = true
+			case string(FIRST_CREATOR), string(FIRST_OPPONENT), string(FIRST_RANDOM):
+				options.First = First(word)
+			case string(BOTTOM), string(TOP):
+				options.Side = Side(word)
+			default:
+				if _, err := find_ruleset(word); word == "" || err != nil {
+					return "ERR unknown option " + field
+				}
+				options.Rules = word
+			}
+		}
+		lobby_id, gw := new_lobby(s.player_id, options)
+		s.lobby_id = lobby_id
+		return fmt.Sprintf("OK %v %v", lobby_id, seat_of(gw, s.player_id))
+	case "JOIN":
+		if len(fields) != 2 {
+			return "ERR usage: JOIN <code>"
+		}
+		lobby_id := strings.ToUpper(fields[1])
+		gw, _, err := join_lobby(lobby_id, s.player_id)
+		if err != nil {
+			return "ERR " + err.Error()
+		}
+		s.lobby_id = lobby_id
+		return fmt.Sprintf("OK %v", seat_of(gw, s.player_id))
+	case "DRAFT":
+		if len(fields) != 2 {
+			return "ERR usage: DRAFT <element>"
+		}
+		gw, err := draft(s.lobby_id, s.player_id, Element(strings.ToLower(fields[1])))
+		if err != nil {
+			return "ERR " + err.Error()
+		}
+		if state := draft_state(gw); state != nil {
+			return fmt.Sprintf("OK next seat %v", state.Seat)
+		}
+		return "OK draft over"
+	case "LOADOUT":
+		spells := [LOADOUT_SIZE]SpellID{}
+		if len(fields) != 1+LOADOUT_SIZE {
+			return fmt.Sprintf("ERR usage: LOADOUT and %v spells", LOADOUT_SIZE)
+		}
+		for i := range spells {
+			spells[i] = SpellID(strings.ToLower(fields[1+i]))
+		}
+		gw, err := loadout(s.lobby_id, s.player_id, spells)
+		if err != nil {
+			return "ERR " + err.Error()
+		}
+		if choosing(gw) {
+			return "OK waiting for the other loadout"
+		}
+		return "OK loadouts chosen"
+	case "BOARD":
+		gw, ok := read_lobby(s.lobby_id, s.player_id)
+		if !ok {
+			return "ERR Invalid Lobby ID"
+		}
+		return "OK\n" + board_text(gw.Rules, gw.Game, seat_of(gw, s.player_id))
+	case "MOVE", "ATTACK":
+		pos, err := parse_positions(fields[1:], 2)
+		if err != nil {
+			return "ERR " + err.Error()
+		}
+		action = Action{Type: ActionType(strings.ToLower(command)), From: pos[0], To: pos[1]}
+	case "SPELL":
+		if len(fields) < 2 {
+			return "ERR usage: SPELL <name> [<row> <col>]"
+		}
+		action = Action{Type: SPELL, Spell: SpellID(strings.ToLower(fields[1])), From: Pos{-1, -1}, To: Pos{-1, -1}}
+		spell, ok := SPELL_REGISTRY[action.Spell]
+		switch {
+		case ok && spell.info().Target == TARGET_OWN_PAIR:
+			pos, err := parse_positions(fields[2:], 2)
+			if err != nil {
+				return "ERR " + err.Error()
+			}
+			action.From, action.To = pos[0], pos[1]
+		case !ok || spell.info().Target != TARGET_NONE:
+			pos, err := parse_positions(fields[2:], 1)
+			if err != nil {
+				return "ERR " + err.Error()
+			}
+			action.To = pos[0]
+		}
+	case "SKIP":
+		action = Action{Type: SKIP}
+	default:
+		return "ERR unknown command, try HELP"
+	}
+
+	gw, ok, err := act(s.lobby_id, s.player_id, action)
+	if err != nil {
+		return "ERR " + err.Error()
+	}
+	if !ok {
+		return "ERR not your turn"
+	}
+	if cascade := last_cascade(gw); cascade != nil {
+		charges := 0
+		for _, step := range cascade.Steps {
+			charges += step.Charges
+		}
+		return fmt.Sprintf("OK merged in %v steps for %v charges", len(cascade.Steps), charges)
+	}
+	return "OK"
+	// >>>
+}
+
+func handle_tcp(conn net.Conn) {
+	// <<<
+	defer conn.Close()
+
+	session := tcp_session{player_id: make_id(16)}
+	scanner := bufio.NewScanner(conn)
+	writer := bufio.NewWriter(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.EqualFold(line, "QUIT") {
+			writer.WriteString("OK bye\n")
+			writer.Flush()
+			return
+		}
+		if response := session.execute(line); response != "" {
+			writer.WriteString(response + "\n")
+			writer.Flush()
+		}
+	}
+	// >>>
+}
+
+func serve_tcp(addr string) {
+	// <<<
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Line protocol is running on %v\n", addr)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		go handle_tcp(conn)
+	}
+	// >>>
+}
@@ -0,0 +1,562 @@
+package main
+
+// Terminal client. Talks to the same HTTP API as client.js and reads one
+// command per line from stdin, so it works over SSH and from scripts:
+//
+//	go run ./term -server http://localhost:6969
+//	printf 'join ABCDEF\nwait\nmove 7 3 7 4\n' | go run ./term
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Pos struct {
+	// <<<
+	Row int `json:"row"`
+	Col int `json:"col"`
+	// >>>
+}
+
+type Action struct {
+	// <<<
+	Type  string `json:"type"`
+	Spell string `json:"spell"`
+	From  Pos    `json:"from"`
+	To    Pos    `json:"to"`
+	// >>>
+}
+
+type GameSOA struct {
+	// <<<
+	BoardSOA struct {
+		Type    [][]string `json:"type"`
+		Element [][]string `json:"element"`
+		Health  [][]int    `json:"health"`
+		Level   [][]int    `json:"level"`
+		Status  [][][4]int `json:"status"` // turns left by BURN, STUN, SHIELD and HASTE
+	} `json:"board_soa"`
+	Players      [2][5]int    `json:"players"`  // charges by loadout slot
+	Loadouts     [2][5]string `json:"loadouts"` // spells by seat
+	ActivePlayer int          `json:"active_player"`
+	Turn         int          `json:"turn"`
+	// >>>
+}
+
+// the parts of the rules of a lobby the client needs, see RulesInfo
+type Rules struct {
+	// <<<
+	Name   string `json:"name"`
+	Spells []struct {
+		Spell   string `json:"spell"`
+		Target  string `json:"target"`
+		Charges int    `json:"charges"`
+	} `json:"spells"`
+	// >>>
+}
+
+// the order of the status effects of a cell, like effects in the rules
+const ( // <<<
+	BURN = iota
+	STUN
+	SHIELD
+	HASTE
+) // >>>
+
+var (
+	COLORS = map[string][3]int{ // first color of COLORS in client.js
+		"air":    {0x06, 0xb6, 0xd4},
+		"rock":   {0x71, 0x71, 0x7a},
+		"fire":   {0xfb, 0x92, 0x3c},
+		"water":  {0x0e, 0xa5, 0xe9},
+		"nature": {0x22, 0xc5, 0x5e},
+		"energy": {0xd9, 0x46, 0xef},
+	}
+)
+
+var client = struct {
+	// <<<
+	server       string
+	player_id    string
+	lobby_id     string
+	player_index int
+	game         *GameSOA
+	rules        Rules
+	// >>>
+}{}
+
+// =============================================================================
+
+func request(method, path string, body any, result any) error {
+	// <<<
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, client.server+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		text, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%v", strings.SplitN(string(text), "\n", 2)[0])
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+	// >>>
+}
+
+func new_player() error {
+	// <<<
+	var response struct {
+		PlayerID string `json:"player_id"`
+	}
+	if err := request(http.MethodGet, "/api/new/player", nil, &response); err != nil {
+		return err
+	}
+	client.player_id = response.PlayerID
+	return nil
+	// >>>
+}
+
+// options are the words of the command, e.g. "random top"
+func new_lobby(words []string) error {
+	// <<<
+	options := map[string]any{}
+	for _, word := range words {
+		switch word = strings.ToLower(word); word {
+		case "loadout":
+			options["loadout"] = true
+		case "creator", "opponent", "random":
+			options["first"] = word
+		case "bottom", "top":
+			options["side"] = word
+		default:
+			options["rules"] = word // the server knows the rulesets
+		}
+	}
+	var response struct {
+		LobbyID     string  `json:"lobby_id"`
+		GameSOA     GameSOA `json:"game_soa"`
+		PlayerIndex int     `json:"player_index"`
+		Rules       Rules   `json:"rules"`
+	}
+	err := request(http.MethodPost, "/api/new/lobby", map[string]any{
+		"player_id": client.player_id,
+		"options":   options,
+	}, &response)
+	if err != nil {
+		return err
+	}
+	client.lobby_id = response.LobbyID
+	client.player_index = response.PlayerIndex
+	client.game = &response.GameSOA
+	client.rules = response.Rules
+	fmt.Printf("lobby %v\n", client.lobby_id)
+	return nil
+	// >>>
+}
+
+func join_lobby(lobby_id string) error {
+	// <<<
+	var response struct {
+		Ok          bool    `json:"ok"`
+		GameSOA     GameSOA `json:"game_soa"`
+		PlayerIndex int     `json:"player_index"`
+		Rules       Rules   `json:"rules"`
+	}
+	lobby_id = strings.ToUpper(lobby_id)
+	err := request(http.MethodPost, "/api/join", map[string]string{
+		"player_id": client.player_id,
+		"lobby_id":  lobby_id,
+	}, &response)
+	if err != nil {
+		return err
+	}
+	client.lobby_id = lobby_id
+	client.player_index = response.PlayerIndex
+	client.game = &response.GameSOA
+	client.rules = response.Rules
+	return nil
+	// >>>
+}
+
+func read_game() error {
+	// <<<
+	var response struct {
+		Ok      bool    `json:"ok"`
+		GameSOA GameSOA `json:"game_soa"`
+	}
+	err := request(http.MethodPost, "/api/read", map[string]string{
+		"lobby_id":  client.lobby_id,
+		"player_id": client.player_id,
+	}, &response)
+	if err != nil {
+		return err
+	}
+	client.game = &response.GameSOA
+	return nil
+	// >>>
+}
+
+// the loadout must name as many spells as the server expects, e.g. 5
+func send_loadout(spells []string) error {
+	// <<<
+	var response struct {
+		Ok      bool    `json:"ok"`
+		GameSOA GameSOA `json:"game_soa"`
+	}
+	for i := range spells {
+		spells[i] = strings.ToLower(spells[i])
+	}
+	err := request(http.MethodPost, "/api/loadout", map[string]any{
+		"lobby_id":  client.lobby_id,
+		"player_id": client.player_id,
+		"spells":    spells,
+	}, &response)
+	if err != nil {
+		return err
+	}
+	client.game = &response.GameSOA
+	return nil
+	// >>>
+}
+
+func send_action(action Action) error {
+	// <<<
+	var response struct {
+		Ok      bool    `json:"ok"`
+		GameSOA GameSOA `json:"game_soa"`
+		Cascade struct {
+			Steps []struct {
+				Combo   int `json:"combo"`
+				Charges int `json:"charges"`
+			} `json:"steps"`
+		} `json:"cascade"`
+	}
+	err := request(http.MethodPost, "/api/action", map[string]any{
+		"lobby_id":  client.lobby_id,
+		"player_id": client.player_id,
+		"action":    action,
+	}, &response)
+	if err != nil {
+		return err
+	}
+	client.game = &response.GameSOA
+	if !response.Ok {
+		return fmt.Errorf("action was not accepted, is it your turn?")
+	}
+	for i, step := range response.Cascade.Steps {
+		fmt.Printf("merge %v: combo x%v, +%v charges\n", i+1, step.Combo, step.Charges)
+	}
+	return nil
+	// >>>
+}
+
+// =============================================================================
+
+// the board is shown from the player's side like in client.js, so the rows
+// the user types are flipped back for player 1
+func to_server(p Pos) Pos {
+	// <<<
+	if client.player_index == 1 {
+		p.Row = board_size() - 1 - p.Row
+	}
+	return p
+	// >>>
+}
+
+func spell_target(spell string) string {
+	// <<<
+	for _, s := range client.rules.Spells {
+		if s.Spell == spell {
+			return s.Target
+		}
+	}
+	return ""
+	// >>>
+}
+
+func spell_charges(spell string) int {
+	// <<<
+	for _, s := range client.rules.Spells {
+		if s.Spell == spell {
+			return s.Charges
+		}
+	}
+	return 0
+	// >>>
+}
+
+// the board is square, 0 until there is a game
+func board_size() int {
+	// <<<
+	if client.game == nil {
+		return 0
+	}
+	return len(client.game.BoardSOA.Type)
+	// >>>
+}
+
+func render() {
+	// <<<
+	g := client.game
+	if g == nil {
+		fmt.Println("no game, use 'new' or 'join <code>'")
+		return
+	}
+
+	size := board_size()
+	var sb strings.Builder
+	sb.WriteString("    ")
+	for col := 0; col < size; col++ {
+		fmt.Fprintf(&sb, "%3d", col)
+	}
+	sb.WriteString("\n")
+
+	for r := 0; r < size; r++ {
+		row := to_server(Pos{r, 0}).Row
+		fmt.Fprintf(&sb, "%3d ", r)
+		for col := 0; col < size; col++ {
+			bg := [3]int{0x16, 0x8a, 0x4a} // green half
+			if r >= size/2 {
+				bg = [3]int{0x0e, 0x74, 0xa8} // blue half
+			}
+			if (row+col)%2 == 0 {
+				bg = [3]int{bg[0] * 3 / 4, bg[1] * 3 / 4, bg[2] * 3 / 4}
+			}
+
+			text := "   "
+			fg := [3]int{0xff, 0xff, 0xff}
+			switch g.BoardSOA.Type[row][col] {
+			case "block":
+				bg = [3]int{0x47, 0x55, 0x69}
+				text = "###"
+			case "elemental":
+				element := g.BoardSOA.Element[row][col]
+				bg = COLORS[element]
+				fg = [3]int{0x11, 0x18, 0x27}
+				health := strconv.Itoa(g.BoardSOA.Health[row][col])
+				if len(health) > 1 {
+					health = "+"
+				}
+				text = fmt.Sprintf("%c%d%v", strings.ToUpper(element)[0], g.BoardSOA.Level[row][col], health)
+				status := [4]int{}
+				if g.BoardSOA.Status != nil {
+					status = g.BoardSOA.Status[row][col]
+				}
+				if status[SHIELD] > 0 {
+					text = "\x1b[4m" + text // underlined while shielded
+				}
+				if status[HASTE] > 0 {
+					text = "\x1b[1m" + text
+				}
+				if status[BURN] > 0 {
+					fg = [3]int{0xb9, 0x1c, 0x1c}
+				}
+				if status[STUN] > 0 {
+					fg = [3]int{0xe0, 0xf2, 0xfe}
+				}
+			}
+			fmt.Fprintf(&sb, "\x1b[48;2;%d;%d;%dm\x1b[38;2;%d;%d;%dm%v\x1b[0m",
+				bg[0], bg[1], bg[2], fg[0], fg[1], fg[2], text)
+		}
+		sb.WriteString("\n")
+	}
+
+	fmt.Fprintf(&sb, "lobby %v | turn %v | ", client.lobby_id, g.Turn)
+	if g.ActivePlayer == client.player_index {
+		sb.WriteString("your move\n")
+	} else {
+		sb.WriteString("opponent's move\n")
+	}
+	for i, s := range g.Loadouts[client.player_index] {
+		fmt.Fprintf(&sb, "%v %v/%v  ", s, g.Players[client.player_index][i], spell_charges(s))
+	}
+	sb.WriteString("\n")
+
+	fmt.Print(sb.String())
+	// >>>
+}
+
+func parse_ints(fields []string, n int) ([]int, error) {
+	// <<<
+	if len(fields) != n {
+		return nil, fmt.Errorf("expected %v numbers", n)
+	}
+	result := make([]int, n)
+	for i, f := range fields {
+		v, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", f)
+		}
+		result[i] = v
+	}
+	return result, nil
+	// >>>
+}
+
+func wait_for_turn() error {
+	// <<<
+	for {
+		if err := read_game(); err != nil {
+			return err
+		}
+		if client.game.ActivePlayer == client.player_index {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	// >>>
+}
+
+const HELP = `commands (rows and columns as shown on the board):
+  new [loadout] [first] [side] [rules]
+                          create a lobby, loadout lets both players choose
+                          their spells, first is creator, opponent or
+                          random, side is bottom or top and rules is the
+                          name of a ruleset, e.g. quick
+  join <code>             join a lobby
+  loadout <spell> ...     choose five spells, e.g. fs sh sw fz su
+  board                   refresh and show the board
+  wait                    block until it is your turn
+  move <r> <c> <r> <c>    move an elemental
+  attack <r> <c> <r> <c>  attack with an elemental
+  spell <name> [<r> <c>]  cast one of the spells below the board, swaps
+                          take two cells
+  skip                    end the turn
+  quit`
+
+func execute(line string) error {
+	// <<<
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	command := strings.ToLower(fields[0])
+	if command != "new" && command != "join" && command != "help" && client.lobby_id == "" {
+		return fmt.Errorf("not in a lobby")
+	}
+
+	switch command {
+	case "help":
+		fmt.Println(HELP)
+		return nil
+	case "new":
+		if err := new_lobby(fields[1:]); err != nil {
+			return err
+		}
+	case "join":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: join <code>")
+		}
+		if err := join_lobby(fields[1]); err != nil {
+			return err
+		}
+	case "board":
+		if err := read_game(); err != nil {
+			return err
+		}
+	case "loadout":
+		if err := send_loadout(fields[1:]); err != nil {
+			return err
+		}
+	case "wait":
+		if err := wait_for_turn(); err != nil {
+			return err
+		}
+	case "move", "attack":
+		v, err := parse_ints(fields[1:], 4)
+		if err != nil {
+			return err
+		}
+		err = send_action(Action{
+			Type: command,
+			From: to_server(Pos{v[0], v[1]}),
+			To:   to_server(Pos{v[2], v[3]}),
+		})
+		if err != nil {
+			return err
+		}
+	case "spell":
+		if len(fields) < 2 {
+			return fmt.Errorf("usage: spell <name> [<row> <col>]")
+		}
+		action := Action{Type: "spell", Spell: strings.ToLower(fields[1]), From: Pos{-1, -1}, To: Pos{-1, -1}}
+		switch spell_target(action.Spell) {
+		case "none":
+		case "own_pair":
+			v, err := parse_ints(fields[2:], 4)
+			if err != nil {
+				return err
+			}
+			action.From = to_server(Pos{v[0], v[1]})
+			action.To = to_server(Pos{v[2], v[3]})
+		default:
+			v, err := parse_ints(fields[2:], 2)
+			if err != nil {
+				return err
+			}
+			action.To = to_server(Pos{v[0], v[1]})
+		}
+		if err := send_action(action); err != nil {
+			return err
+		}
+	case "skip":
+		if err := send_action(Action{Type: "skip"}); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown command %q, try 'help'", fields[0])
+	}
+
+	render()
+	return nil
+	// >>>
+}
+
+func main() {
+	// <<<
+	flag.StringVar(&client.server, "server", "http://localhost:6969", "server address")
+	flag.Parse()
+	client.server = strings.TrimRight(client.server, "/")
+
+	if err := new_player(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	fmt.Print("> ")
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "quit" || line == "exit" {
+			return
+		}
+		if err := execute(line); err != nil {
+			fmt.Println("error:", err)
+		}
+		fmt.Print("> ")
+	}
+	// >>>
+}
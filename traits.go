@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Rulesets may give every element a Trait with a strength and add an
+// advantage table to the damage of attacks, both are left out by the classic
+// rules so elements only matter for merges there. Traits work on attacks and
+// at the end of every turn of the owner, see attack and apply_traits.
+
+type Trait string
+
+const ( // <<<
+	TRAIT_HEAL   Trait = "heal"   // heals the own neighbours up, down, left and right by the strength at the end of every own turn
+	TRAIT_ARMOR  Trait = "armor"  // takes damage reduced by the strength, at least 1
+	TRAIT_WIDE   Trait = "wide"   // attacks the strength more columns to both sides
+	TRAIT_SPLASH Trait = "splash" // attacks also hit the enemies left and right of the target by the strength
+	TRAIT_THORNS Trait = "thorns" // attackers take the strength as damage back when it survives
+	TRAIT_REACH  Trait = "reach"  // the strength more reach
+) // >>>
+
+var TRAITS = []Trait{TRAIT_HEAL, TRAIT_ARMOR, TRAIT_WIDE, TRAIT_SPLASH, TRAIT_THORNS, TRAIT_REACH}
+
+type ElementTrait struct {
+	// <<<
+	Trait    Trait `json:"trait"`
+	Strength int   `json:"strength"`
+	// >>>
+}
+
+// the strength of the trait of the element of cell, 0 when it has another one
+func trait_strength(rules Ruleset, cell Cell, trait Trait) int {
+	// <<<
+	if cell.Type != ELEMENTAL {
+		return 0
+	}
+	t, ok := rules.Traits[cell.Element]
+	if !ok || t.Trait != trait {
+		return 0
+	}
+	return t.Strength
+	// >>>
+}
+
+// the columns an elemental attacks to both sides of its own
+func col_reach(rules Ruleset, cell Cell) int {
+	return 1 + trait_strength(rules, cell, TRAIT_WIDE)
+}
+
+// the damage of an attack before the armor of the target
+func attack_damage(rules Ruleset, from, to Cell) int {
+	// <<<
+	damage := rules.Damage[from.Level-1] + rules.Advantage[from.Element][to.Element]
+	return max(damage, 0)
+	// >>>
+}
+
+func armor(rules Ruleset, cell Cell, damage int) int {
+	// <<<
+	strength := trait_strength(rules, cell, TRAIT_ARMOR)
+	if damage <= 0 || strength == 0 {
+		return damage
+	}
+	return max(damage-strength, 1)
+	// >>>
+}
+
+// an attack which passed can_attack, with the area of the level of the
+// attacker, its splash and the thorns of the target. The other cells of the
+// area take half the damage.
+func attack(rules Ruleset, game *Game, from, to Pos) {
+	// <<<
+	attacker, target := game.Board[from.Row][from.Col], game.Board[to.Row][to.Col]
+	apply_damage(rules, game, to.Row, to.Col, attack_damage(rules, attacker, target))
+
+	for _, p := range area_cells(*game, rules.Areas[attacker.Level-1], to) {
+		if p != to && game.Board[p.Row][p.Col].Type == ELEMENTAL {
+			apply_damage(rules, game, p.Row, p.Col, attack_damage(rules, attacker, game.Board[p.Row][p.Col])/2)
+		}
+	}
+
+	if splash := trait_strength(rules, attacker, TRAIT_SPLASH); splash > 0 {
+		for _, col := range [2]int{to.Col - 1, to.Col + 1} {
+			if valid(game.Board, to.Row, col) && game.Board[to.Row][col].Type == ELEMENTAL {
+				apply_damage(rules, game, to.Row, col, splash)
+			}
+		}
+	}
+	if thorns := trait_strength(rules, target, TRAIT_THORNS); thorns > 0 && game.Board[to.Row][to.Col].Type == ELEMENTAL {
+		apply_damage(rules, game, from.Row, from.Col, thorns)
+	}
+	// >>>
+}
+
+// the end of a turn of seat, before the effects tick
+func apply_traits(rules Ruleset, game *Game, seat int) {
+	// <<<
+	board := game.Board
+	healed := make_grid[int](len(board)) // so healers do not heal by what was healed this turn
+	for row := range board {
+		for col := range board[row] {
+			strength := trait_strength(rules, board[row][col], TRAIT_HEAL)
+			if owner(board, row) != seat || strength == 0 {
+				continue
+			}
+			for _, d := range [4]Pos{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+				next := Pos{row + d.Row, col + d.Col}
+				if valid(board, next.Row, next.Col) && owner(board, next.Row) == seat &&
+					board[next.Row][next.Col].Type == ELEMENTAL {
+					healed[next.Row][next.Col] += strength
+				}
+			}
+		}
+	}
+	for row := range board {
+		for col := range board[row] {
+			if healed[row][col] > 0 {
+				cell := &board[row][col]
+				cell.Health = min(cell.Health+healed[row][col], rules.Health[cell.Level-1])
+			}
+		}
+	}
+	// >>>
+}
+
+func validate_traits(rules Ruleset) error {
+	// <<<
+	for element, t := range rules.Traits {
+		if !slices.Contains(ELEMENTS, element) {
+			return fmt.Errorf("traits of an invalid element %q", element)
+		}
+		if !slices.Contains(TRAITS, t.Trait) || t.Strength < 1 {
+			return fmt.Errorf("invalid trait %q of %v", t.Trait, element)
+		}
+	}
+	for from, row := range rules.Advantage {
+		for to := range row {
+			if !slices.Contains(ELEMENTS, from) || !slices.Contains(ELEMENTS, to) {
+				return fmt.Errorf("advantage of an invalid element")
+			}
+		}
+	}
+	return nil
+	// >>>
+}